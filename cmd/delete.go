@@ -16,7 +16,6 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
@@ -34,6 +33,7 @@ type deleteFlags struct {
 	hashTo   string
 	all      bool
 	dryrun   bool
+	name     string
 }
 
 func NewDeleteCommand() *cobra.Command {
@@ -72,6 +72,7 @@ func NewDeleteCommand() *cobra.Command {
 	command.PersistentFlags().StringVar(&deleteFlags.hashFrom, "from", "", "commit or diff hash to which ghost branches are deleted.")
 	command.PersistentFlags().StringVar(&deleteFlags.hashTo, "to", "", "commit or diff hash from which ghost branches are deleted.")
 	command.PersistentFlags().BoolVar(&deleteFlags.all, "all", false, "flag to ensure multiple ghost branches.")
+	command.PersistentFlags().StringVar(&deleteFlags.name, "name", "", "delete ghost branches named by 'push --name'. the name itself is deleted if no ghost branches named by it remain, otherwise it is updated to the rest.")
 	command.PersistentFlags().BoolVar(&deleteFlags.dryrun, "dry-run", false, "If true, only print the branch names that would be deleted, without deleting them.")
 	return command
 }
@@ -80,8 +81,7 @@ func runDeleteCommitsCommand(flags *deleteFlags) func(cmd *cobra.Command, args [
 	return func(cmd *cobra.Command, args []string) {
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(deleteResultKind, err)
 		}
 		opts := ghost.DeleteOptions{
 			WorkingEnvSpec: types.WorkingEnvSpec{
@@ -96,11 +96,20 @@ func runDeleteCommitsCommand(flags *deleteFlags) func(cmd *cobra.Command, args [
 			},
 			Dryrun: flags.dryrun,
 		}
+		if flags.name != "" {
+			err = flags.applyName(&opts)
+			if err != nil {
+				exitWithError(deleteResultKind, err)
+			}
+		}
 
 		res, err := ghost.Delete(opts)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(deleteResultKind, err)
+		}
+		if structuredOutput() {
+			printResult(deleteResultKind, newDeleteOutput(res, flags.dryrun))
+			return
 		}
 		fmt.Print(res.PrettyString())
 	}
@@ -110,8 +119,7 @@ func runDeleteDiffCommand(flags *deleteFlags) func(cmd *cobra.Command, args []st
 	return func(cmd *cobra.Command, args []string) {
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(deleteResultKind, err)
 		}
 		opts := ghost.DeleteOptions{
 			WorkingEnvSpec: types.WorkingEnvSpec{
@@ -126,11 +134,20 @@ func runDeleteDiffCommand(flags *deleteFlags) func(cmd *cobra.Command, args []st
 			},
 			Dryrun: flags.dryrun,
 		}
+		if flags.name != "" {
+			err = flags.applyName(&opts)
+			if err != nil {
+				exitWithError(deleteResultKind, err)
+			}
+		}
 
 		res, err := ghost.Delete(opts)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(deleteResultKind, err)
+		}
+		if structuredOutput() {
+			printResult(deleteResultKind, newDeleteOutput(res, flags.dryrun))
+			return
 		}
 		fmt.Print(res.PrettyString())
 	}
@@ -140,8 +157,7 @@ func runDeleteAllCommand(flags *deleteFlags) func(cmd *cobra.Command, args []str
 	return func(cmd *cobra.Command, args []string) {
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(deleteResultKind, err)
 		}
 		opts := ghost.DeleteOptions{
 			WorkingEnvSpec: types.WorkingEnvSpec{
@@ -161,19 +177,68 @@ func runDeleteAllCommand(flags *deleteFlags) func(cmd *cobra.Command, args []str
 			},
 			Dryrun: flags.dryrun,
 		}
+		if flags.name != "" {
+			err = flags.applyName(&opts)
+			if err != nil {
+				exitWithError(deleteResultKind, err)
+			}
+		}
 
 		res, err := ghost.Delete(opts)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(deleteResultKind, err)
+		}
+		if structuredOutput() {
+			printResult(deleteResultKind, newDeleteOutput(res, flags.dryrun))
+			return
 		}
 		fmt.Print(res.PrettyString())
 	}
 }
 
 func (flags deleteFlags) validate() errors.GitGhostError {
+	if flags.name != "" {
+		if flags.hashFrom != "" || flags.hashTo != "" {
+			return errors.Errorf("from and to can't be used with name")
+		}
+		return nil
+	}
 	if (flags.hashFrom == "" || flags.hashTo == "") && !flags.all && !flags.dryrun {
 		return errors.Errorf("all must be set if multiple ghosts branches are deleted")
 	}
 	return nil
 }
+
+// applyName replaces ghost branches to be deleted with ones of the name, and deletes the name too
+func (flags deleteFlags) applyName(opts *ghost.DeleteOptions) errors.GitGhostError {
+	alias, err := resolveName(flags.name)
+	if err != nil {
+		return err
+	}
+	commitsBranch := alias.CommitsBranch()
+	diffBranch := alias.DiffBranch()
+	if opts.ListCommitsBranchSpec != nil && opts.ListDiffBranchSpec != nil {
+		if commitsBranch == nil {
+			opts.ListCommitsBranchSpec = nil
+		}
+		if diffBranch == nil {
+			opts.ListDiffBranchSpec = nil
+		}
+	}
+	if opts.ListCommitsBranchSpec != nil {
+		if commitsBranch == nil {
+			return errors.Errorf("ghost named %s has no commits", flags.name)
+		}
+		opts.ListCommitsBranchSpec.HashFrom = commitsBranch.CommitHashFrom
+		opts.ListCommitsBranchSpec.HashTo = commitsBranch.CommitHashTo
+	}
+	if opts.ListDiffBranchSpec != nil {
+		if diffBranch == nil {
+			return errors.Errorf("ghost named %s has no diff", flags.name)
+		}
+		opts.ListDiffBranchSpec.HashFrom = diffBranch.CommitHashFrom
+		opts.ListDiffBranchSpec.HashTo = diffBranch.DiffHash
+	}
+	opts.Alias = alias
+	return nil
+}
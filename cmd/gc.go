@@ -16,19 +16,105 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 
 	"github.com/spf13/cobra"
 )
 
 func init() {
-	RootCmd.AddCommand(gcCmd)
+	RootCmd.AddCommand(NewGCCommand())
+}
+
+type gcFlags struct {
+	olderThan    string
+	unreachable  bool
+	sourceRemote string
+	mergedInto   string
+	dryrun       bool
+}
+
+func NewGCCommand() *cobra.Command {
+	var (
+		gcFlags gcFlags
+	)
+
+	command := &cobra.Command{
+		Use:   "gc",
+		Short: "gc ghost branches from remote repository.",
+		Long:  "gc ghost branches of commits and diffs from remote repository.  If multiple conditions are specified, ghost branches matching all of them are deleted.",
+		Args:  cobra.NoArgs,
+		Run:   runGCCommand(&gcFlags),
+	}
+	command.Flags().StringVar(&gcFlags.olderThan, "older-than", "", "delete ghost branches whose commits are older than this duration (e.g. 30d, 2w, 12h).")
+	command.Flags().BoolVar(&gcFlags.unreachable, "unreachable", false, "delete ghost branches whose base commits are unreachable from the source remote.  heads of branches of the source remote are fetched without updating remote tracking branches.  not available in a shallow clone.")
+	command.Flags().StringVar(&gcFlags.sourceRemote, "source-remote", "origin", "remote name of the source repository used with --unreachable.")
+	command.Flags().StringVar(&gcFlags.mergedInto, "merged-into", "", "delete ghost branches whose patches are already contained in this committish of the source repository (e.g. origin/main), compared by git patch-id.")
+	command.Flags().BoolVar(&gcFlags.dryrun, "dry-run", false, "If true, only print the branch names that would be deleted, without deleting them.")
+	return command
+}
+
+func runGCCommand(flags *gcFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		err := flags.validate()
+		if err != nil {
+			errors.LogErrorWithStack(err)
+			os.Exit(1)
+		}
+		opts := ghost.GCOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			Prefix:         globalOpts.ghostPrefix,
+			Unreachable:    flags.unreachable,
+			SourceRemote:   flags.sourceRemote,
+			MergedInto:     flags.mergedInto,
+			Dryrun:         flags.dryrun,
+		}
+		if flags.olderThan != "" {
+			olderThan, err := flags.parseOlderThan()
+			if err != nil {
+				errors.LogErrorWithStack(err)
+				os.Exit(1)
+			}
+			opts.OlderThan = &olderThan
+		}
+
+		res, err := ghost.GC(opts)
+		if err != nil {
+			errors.LogErrorWithStack(err)
+			os.Exit(1)
+		}
+		fmt.Print(res.PrettyString())
+	}
+}
+
+func (flags gcFlags) validate() errors.GitGhostError {
+	if flags.olderThan == "" && !flags.unreachable && flags.mergedInto == "" {
+		return errors.New("one of older-than, unreachable or merged-into must be specified")
+	}
+	if flags.mergedInto != "" {
+		if err := isValidCommittish("merged-into", flags.mergedInto); err != nil {
+			return err
+		}
+	}
+	if flags.unreachable {
+		if err := nonEmpty("source-remote", flags.sourceRemote); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-var gcCmd = &cobra.Command{
-	Use:   "gc",
-	Short: "gc ghost commits from remote repository.",
-	Long:  "gc ghost commits from remote repository.",
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("gc command")
-	},
+func (flags gcFlags) parseOlderThan() (time.Duration, errors.GitGhostError) {
+	d, err := util.ParseDuration(flags.olderThan)
+	if err != nil {
+		return 0, errors.Errorf("older-than is not a valid duration (value: %v)", flags.olderThan)
+	}
+	if d < 0 {
+		return 0, errors.Errorf("older-than must not be negative (value: %v)", flags.olderThan)
+	}
+	return d, nil
 }
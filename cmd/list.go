@@ -16,7 +16,6 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"regexp"
 	"strings"
 
@@ -31,7 +30,7 @@ func init() {
 	RootCmd.AddCommand(NewListCommand())
 }
 
-var outputTypes = []string{"only-from", "only-to"}
+var outputTypes = []string{"only-from", "only-to", outputFormatJSON, outputFormatYAML}
 var regexpOutputPattern = regexp.MustCompile("^(|" + strings.Join(outputTypes, "|") + ")$")
 
 type listFlags struct {
@@ -39,6 +38,8 @@ type listFlags struct {
 	hashTo    string
 	noHeaders bool
 	output    string
+	manifest  bool
+	selector  []string
 }
 
 func NewListCommand() *cobra.Command {
@@ -77,34 +78,37 @@ func NewListCommand() *cobra.Command {
 	command.PersistentFlags().StringVar(&listFlags.hashFrom, "from", "", "commit or diff hash to which ghost branches are listed.")
 	command.PersistentFlags().StringVar(&listFlags.hashTo, "to", "", "commit or diff hash from which ghost branches are listed.")
 	command.PersistentFlags().BoolVar(&listFlags.noHeaders, "no-headers", false, "When using the default, only-from or only-to output format, don't print headers (default print headers).")
-	command.PersistentFlags().StringVarP(&listFlags.output, "output", "o", "", "Output format. One of: only-from|only-to")
+	command.PersistentFlags().StringVarP(&listFlags.output, "output", "o", "", "Output format. One of: only-from|only-to|json|yaml")
+	command.PersistentFlags().StringArrayVarP(&listFlags.selector, "selector", "l", []string{}, "list only ghost branches having a label in key=value form, this flag can be repeated to require all labels.  ghost branches are fetched to read them.")
+	command.PersistentFlags().BoolVar(&listFlags.manifest, "manifest", false, "show creation time and author recorded in manifests of ghost branches.  ghost branches are fetched to read them.")
 	return command
 }
 
 func runListCommitsCommand(flags *listFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		flags.applyStructuredOutput()
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(listResultKind, err)
 		}
 		opts := ghost.ListOptions{
-			WorkingEnvSpec: types.WorkingEnvSpec{
-				SrcDir:          globalOpts.srcDir,
-				GhostWorkingDir: globalOpts.ghostWorkDir,
-				GhostRepo:       globalOpts.ghostRepo,
-			},
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
 			ListCommitsBranchSpec: &types.ListCommitsBranchSpec{
 				Prefix:   globalOpts.ghostPrefix,
 				HashFrom: flags.hashFrom,
 				HashTo:   flags.hashTo,
 			},
+			WithManifests: flags.manifest,
+			Selector:      flags.parsedSelector(),
 		}
 
 		res, err := ghost.List(opts)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(listResultKind, err)
+		}
+		if structuredOutput() {
+			printResult(listResultKind, newListOutput(res))
+			return
 		}
 		fmt.Print(res.PrettyString(!flags.noHeaders, flags.output))
 	}
@@ -112,28 +116,29 @@ func runListCommitsCommand(flags *listFlags) func(cmd *cobra.Command, args []str
 
 func runListDiffCommand(flags *listFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		flags.applyStructuredOutput()
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(listResultKind, err)
 		}
 		opts := ghost.ListOptions{
-			WorkingEnvSpec: types.WorkingEnvSpec{
-				SrcDir:          globalOpts.srcDir,
-				GhostWorkingDir: globalOpts.ghostWorkDir,
-				GhostRepo:       globalOpts.ghostRepo,
-			},
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
 			ListDiffBranchSpec: &types.ListDiffBranchSpec{
 				Prefix:   globalOpts.ghostPrefix,
 				HashFrom: flags.hashFrom,
 				HashTo:   flags.hashTo,
 			},
+			WithManifests: flags.manifest,
+			Selector:      flags.parsedSelector(),
 		}
 
 		res, err := ghost.List(opts)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(listResultKind, err)
+		}
+		if structuredOutput() {
+			printResult(listResultKind, newListOutput(res))
+			return
 		}
 		fmt.Print(res.PrettyString(!flags.noHeaders, flags.output))
 	}
@@ -141,17 +146,13 @@ func runListDiffCommand(flags *listFlags) func(cmd *cobra.Command, args []string
 
 func runListAllCommand(flags *listFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		flags.applyStructuredOutput()
 		err := flags.validate()
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(listResultKind, err)
 		}
 		opts := ghost.ListOptions{
-			WorkingEnvSpec: types.WorkingEnvSpec{
-				SrcDir:          globalOpts.srcDir,
-				GhostWorkingDir: globalOpts.ghostWorkDir,
-				GhostRepo:       globalOpts.ghostRepo,
-			},
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
 			ListCommitsBranchSpec: &types.ListCommitsBranchSpec{
 				Prefix:   globalOpts.ghostPrefix,
 				HashFrom: flags.hashFrom,
@@ -162,12 +163,17 @@ func runListAllCommand(flags *listFlags) func(cmd *cobra.Command, args []string)
 				HashFrom: flags.hashFrom,
 				HashTo:   flags.hashTo,
 			},
+			WithManifests: flags.manifest,
+			Selector:      flags.parsedSelector(),
 		}
 
 		res, err := ghost.List(opts)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(listResultKind, err)
+		}
+		if structuredOutput() {
+			printResult(listResultKind, newListOutput(res))
+			return
 		}
 		fmt.Print(res.PrettyString(!flags.noHeaders, flags.output))
 	}
@@ -177,5 +183,24 @@ func (flags listFlags) validate() errors.GitGhostError {
 	if !regexpOutputPattern.MatchString(flags.output) {
 		return errors.Errorf("output must be one of %v", outputTypes)
 	}
+	if _, err := parseLabels("selector", flags.selector); err != nil {
+		return err
+	}
+	if flags.manifest && flags.output != "" && !structuredOutput() {
+		return errors.Errorf("manifest can't be used with output %s", flags.output)
+	}
 	return nil
 }
+
+// applyStructuredOutput makes json and yaml of list's own --output, which shadows the global one, work as the global one
+func (flags listFlags) applyStructuredOutput() {
+	if flags.output == outputFormatJSON || flags.output == outputFormatYAML {
+		globalOpts.output = flags.output
+	}
+}
+
+func (flags listFlags) parsedSelector() map[string]string {
+	// validated in listFlags.validate
+	selector, _ := parseLabels("selector", flags.selector)
+	return selector
+}
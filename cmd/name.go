@@ -0,0 +1,81 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+// rangeArgsOrReference is cobra.RangeArgs which requires no args instead if a name is given,
+// and accepts a token as the only arg
+func rangeArgsOrReference(name *string, min, max int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if *name != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		if len(args) == 1 && types.IsToken(args[0]) {
+			return nil
+		}
+		return cobra.RangeArgs(min, max)(cmd, args)
+	}
+}
+
+func resolveName(name string) (*types.Alias, errors.GitGhostError) {
+	return ghost.ResolveName(ghost.ResolveNameOptions{
+		WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+		Prefix:         globalOpts.ghostPrefix,
+		Name:           name,
+	})
+}
+
+// commitsArgsOfName returns [from-hash] [to-hash] args of the commits branch named name
+func commitsArgsOfName(name string) ([]string, errors.GitGhostError) {
+	alias, err := resolveName(name)
+	if err != nil {
+		return nil, err
+	}
+	if alias.CommitsBranch() == nil {
+		return nil, errors.Errorf("ghost named %s has no commits", name)
+	}
+	return []string{alias.CommitHashFrom, alias.CommitHashTo}, nil
+}
+
+// diffArgsOfName returns [diff-from-hash] [diff-hash] args of the diff branch named name
+func diffArgsOfName(name string) ([]string, errors.GitGhostError) {
+	alias, err := resolveName(name)
+	if err != nil {
+		return nil, err
+	}
+	if alias.DiffBranch() == nil {
+		return nil, errors.Errorf("ghost named %s has no diff", name)
+	}
+	return []string{alias.DiffHashFrom, alias.DiffHash}, nil
+}
+
+// allArgsOfName returns [from-hash] [to-hash] [diff-hash] args of the commits and diff branches named name
+func allArgsOfName(name string) ([]string, errors.GitGhostError) {
+	alias, err := resolveName(name)
+	if err != nil {
+		return nil, err
+	}
+	if alias.CommitsBranch() == nil || alias.DiffBranch() == nil || alias.CommitHashTo != alias.DiffHashFrom {
+		return nil, errors.Errorf("ghost named %s was not pushed by 'push all'", name)
+	}
+	return []string{alias.CommitHashFrom, alias.CommitHashTo, alias.DiffHash}, nil
+}
@@ -0,0 +1,291 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// outputAPIVersion is a version of the schema of documents printed with --output.
+// Fields must not be removed nor changed in meaning without bumping it.
+const outputAPIVersion = "git-ghost/v1"
+
+const (
+	outputFormatJSON = "json"
+	outputFormatYAML = "yaml"
+)
+
+// kinds of documents printed with --output
+const (
+	pushResultKind    = "PushResult"
+	pullResultKind    = "PullResult"
+	listResultKind    = "ListResult"
+	deleteResultKind  = "DeleteResult"
+	showResultKind    = "ShowResult"
+	restoreResultKind = "RestoreResult"
+)
+
+// resultKinds maps subcommands supporting --output to kinds of their documents
+var resultKinds = map[string]string{
+	"push":    pushResultKind,
+	"pull":    pullResultKind,
+	"list":    listResultKind,
+	"delete":  deleteResultKind,
+	"show":    showResultKind,
+	"restore": restoreResultKind,
+}
+
+// outputDocument is a top-level document printed with --output
+type outputDocument struct {
+	APIVersion string       `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string       `json:"kind" yaml:"kind"`
+	GhostRepo  string       `json:"ghostRepo" yaml:"ghostRepo"`
+	Prefix     string       `json:"prefix" yaml:"prefix"`
+	Result     interface{}  `json:"result,omitempty" yaml:"result,omitempty"`
+	Error      *outputError `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type outputError struct {
+	Message string `json:"message" yaml:"message"`
+}
+
+// outputBranch is a ghost branch in documents.  Type is one of "commits" and "diff".
+type outputBranch struct {
+	Type           string          `json:"type" yaml:"type"`
+	Name           string          `json:"name" yaml:"name"`
+	CommitHashFrom string          `json:"commitHashFrom" yaml:"commitHashFrom"`
+	CommitHashTo   string          `json:"commitHashTo,omitempty" yaml:"commitHashTo,omitempty"`
+	DiffHash       string          `json:"diffHash,omitempty" yaml:"diffHash,omitempty"`
+	Manifest       *types.Manifest `json:"manifest,omitempty" yaml:"manifest,omitempty"`
+}
+
+type pushOutput struct {
+	Branches              []outputBranch `json:"branches" yaml:"branches"`
+	UntrackedFiles        []string       `json:"untrackedFiles" yaml:"untrackedFiles"`
+	SkippedUntrackedFiles []string       `json:"skippedUntrackedFiles" yaml:"skippedUntrackedFiles"`
+	Token                 string         `json:"token,omitempty" yaml:"token,omitempty"`
+	// UnannotatedBranches are names of existing ghost branches which labels and message are not recorded on
+	UnannotatedBranches []string `json:"unannotatedBranches,omitempty" yaml:"unannotatedBranches,omitempty"`
+}
+
+type pullOutput struct {
+	Branches []outputBranch `json:"branches" yaml:"branches"`
+	// Files are results per file of 3-way merge with --3way
+	Files []outputFile `json:"files" yaml:"files"`
+	// Worktree is a path of the worktree added by --worktree
+	Worktree string `json:"worktree,omitempty" yaml:"worktree,omitempty"`
+	// Stash is a commit of the stash entry keeping local changes with --autostash if they are not restored cleanly
+	Stash string `json:"stash,omitempty" yaml:"stash,omitempty"`
+	// StashConflicts are files with conflicts between ghost branches and local changes restored with --autostash
+	StashConflicts []string `json:"stashConflicts" yaml:"stashConflicts"`
+	// Distance is a commit distance between the base checked against and ghost branches with --check
+	Distance *outputDistance `json:"distance,omitempty" yaml:"distance,omitempty"`
+	// DiffSkipped is true if the diff is neither applied nor checked because the commits conflict
+	DiffSkipped bool `json:"diffSkipped" yaml:"diffSkipped"`
+}
+
+// outputDistance is numbers of commits only in the base checked against (ahead) and only in the base of ghost branches (behind)
+type outputDistance struct {
+	Base   string `json:"base" yaml:"base"`
+	From   string `json:"from" yaml:"from"`
+	Ahead  int    `json:"ahead" yaml:"ahead"`
+	Behind int    `json:"behind" yaml:"behind"`
+}
+
+// outputFile is a result of applying ghost branches to a file.  Status is one of "clean", "merged", "conflicted" and "pending".
+type outputFile struct {
+	Path   string `json:"path" yaml:"path"`
+	Status string `json:"status" yaml:"status"`
+}
+
+type listOutput struct {
+	Branches []outputBranch `json:"branches" yaml:"branches"`
+}
+
+type deleteOutput struct {
+	Branches []outputBranch `json:"branches" yaml:"branches"`
+	DryRun   bool           `json:"dryRun" yaml:"dryRun"`
+}
+
+type showOutput struct {
+	Content string `json:"content" yaml:"content"`
+}
+
+func validateOutputFormat(format string) errors.GitGhostError {
+	switch format {
+	case "", outputFormatJSON, outputFormatYAML:
+		return nil
+	}
+	return errors.Errorf("output must be one of %s or %s: %s", outputFormatJSON, outputFormatYAML, format)
+}
+
+// structuredOutput returns true if results should be printed as documents
+func structuredOutput() bool {
+	return globalOpts.output != ""
+}
+
+func newOutputBranch(branch types.GhostBranch, manifests map[string]*types.Manifest) outputBranch {
+	out := outputBranch{
+		Name:     branch.BranchName(),
+		Manifest: manifests[branch.BranchName()],
+	}
+	switch b := branch.(type) {
+	case *types.CommitsBranch:
+		out.Type = "commits"
+		out.CommitHashFrom = b.CommitHashFrom
+		out.CommitHashTo = b.CommitHashTo
+	case *types.DiffBranch:
+		out.Type = "diff"
+		out.CommitHashFrom = b.CommitHashFrom
+		out.DiffHash = b.DiffHash
+	}
+	return out
+}
+
+func newOutputBranches(commits *types.CommitsBranches, diffs *types.DiffBranches, manifests map[string]*types.Manifest) []outputBranch {
+	branches := []outputBranch{}
+	if commits != nil {
+		for i := range *commits {
+			branches = append(branches, newOutputBranch(&(*commits)[i], manifests))
+		}
+	}
+	if diffs != nil {
+		for i := range *diffs {
+			branches = append(branches, newOutputBranch(&(*diffs)[i], manifests))
+		}
+	}
+	return branches
+}
+
+func newOutputBranchesOf(commits *types.CommitsBranch, diff *types.DiffBranch) []outputBranch {
+	branches := []outputBranch{}
+	if commits != nil {
+		branches = append(branches, newOutputBranch(commits, nil))
+	}
+	if diff != nil {
+		branches = append(branches, newOutputBranch(diff, nil))
+	}
+	return branches
+}
+
+func newPushOutput(result *ghost.PushResult, token string) pushOutput {
+	out := pushOutput{
+		Branches:              newOutputBranchesOf(result.CommitsBranch, result.DiffBranch),
+		UntrackedFiles:        result.UntrackedFiles,
+		SkippedUntrackedFiles: result.SkippedUntrackedFiles,
+		Token:                 token,
+		UnannotatedBranches:   result.UnannotatedBranches,
+	}
+	if out.UntrackedFiles == nil {
+		out.UntrackedFiles = []string{}
+	}
+	if out.SkippedUntrackedFiles == nil {
+		out.SkippedUntrackedFiles = []string{}
+	}
+	return out
+}
+
+func newPullOutput(result *ghost.PullResult) pullOutput {
+	out := pullOutput{
+		Branches:       newOutputBranchesOf(result.CommitsBranch, result.DiffBranch),
+		Files:          []outputFile{},
+		Worktree:       result.Worktree,
+		Stash:          result.Stash,
+		StashConflicts: result.StashConflicts,
+		DiffSkipped:    result.DiffSkipped,
+	}
+	for _, f := range result.Files {
+		out.Files = append(out.Files, outputFile{Path: f.Path, Status: string(f.Status)})
+	}
+	if d := result.Distance; d != nil {
+		out.Distance = &outputDistance{Base: d.Base, From: d.From, Ahead: d.Ahead, Behind: d.Behind}
+	}
+	return out
+}
+
+func newListOutput(result *ghost.ListResult) listOutput {
+	return listOutput{Branches: newOutputBranches(result.CommitsBranches, result.DiffBranches, result.Manifests)}
+}
+
+func newDeleteOutput(result *ghost.DeleteResult, dryrun bool) deleteOutput {
+	return deleteOutput{
+		Branches: newOutputBranches(result.CommitsBranches, result.DiffBranches, nil),
+		DryRun:   dryrun,
+	}
+}
+
+func writeOutputDocument(writer io.Writer, doc outputDocument) errors.GitGhostError {
+	doc.APIVersion = outputAPIVersion
+	doc.GhostRepo = types.RedactURL(globalOpts.ghostRepo)
+	doc.Prefix = globalOpts.ghostPrefix
+	switch globalOpts.output {
+	case outputFormatYAML:
+		encoder := yaml.NewEncoder(writer)
+		encoder.SetIndent(2)
+		err := encoder.Encode(doc)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(encoder.Close())
+	default:
+		content, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = fmt.Fprintf(writer, "%s\n", content)
+		return errors.WithStack(err)
+	}
+}
+
+// printResult prints result as a document of kind
+func printResult(kind string, result interface{}) {
+	err := writeOutputDocument(os.Stdout, outputDocument{Kind: kind, Result: result})
+	if err != nil {
+		errors.LogErrorWithStack(err)
+		os.Exit(1)
+	}
+}
+
+// resultKindOf returns the kind of documents printed by cmd, or empty string if it prints no documents
+func resultKindOf(cmd *cobra.Command) string {
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		if !c.Parent().HasParent() {
+			return resultKinds[c.Name()]
+		}
+	}
+	return ""
+}
+
+// exitWithError reports err and exits.  err is also printed as a document of kind with --output.
+func exitWithError(kind string, err errors.GitGhostError) {
+	errors.LogErrorWithStack(err)
+	if structuredOutput() {
+		werr := writeOutputDocument(os.Stdout, outputDocument{Kind: kind, Error: &outputError{Message: err.Error()}})
+		if werr != nil {
+			errors.LogErrorWithStack(werr)
+		}
+	}
+	os.Exit(1)
+}
@@ -15,7 +15,10 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
@@ -31,6 +34,51 @@ func init() {
 
 type pullFlags struct {
 	// forceApply bool
+	name      string
+	threeWay  bool
+	worktree  string
+	branch    string
+	autoStash bool
+	check     bool
+	checkBase string
+}
+
+// checkConflictExitCode is an exit code of 'pull --check' when ghost branches conflict with the base
+const checkConflictExitCode = 2
+
+func (flags pullFlags) validate() errors.GitGhostError {
+	if flags.branch != "" && flags.worktree == "" {
+		return errors.New("branch can be used only with worktree")
+	}
+	if flags.checkBase != "" && !flags.check {
+		return errors.New("check-base can be used only with check")
+	}
+	if flags.check && (flags.worktree != "" || flags.autoStash) {
+		return errors.New("check can't be used with worktree nor autostash")
+	}
+	return nil
+}
+
+func (flags pullFlags) applyOptions(options *ghost.PullOptions) errors.GitGhostError {
+	options.AutoStash = flags.autoStash
+	options.Check = flags.check
+	options.CheckBase = flags.checkBase
+	if flags.worktree == "" {
+		return nil
+	}
+	worktree, err := filepath.Abs(flags.worktree)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	options.Worktree = worktree
+	options.WorktreeBranch = flags.branch
+	return nil
+}
+
+func (flags pullFlags) workingEnvSpec() types.WorkingEnvSpec {
+	workingEnvSpec := globalOpts.WorkingEnvSpec()
+	workingEnvSpec.ThreeWay = flags.threeWay
+	return workingEnvSpec
 }
 
 func NewPullCommand() *cobra.Command {
@@ -40,36 +88,86 @@ func NewPullCommand() *cobra.Command {
 	command := &cobra.Command{
 		Use:   "pull [from-hash(default=HEAD)] [diff-hash]",
 		Short: "pull commits(hash1...hash2), diff(hash...current state) from ghost repo and apply them to working dir",
-		Long:  "pull commits or diff or all from ghost repo and apply them to working dir.  If you didn't specify any subcommand, this commands works as an alias for 'pull diff' command.",
-		Args:  cobra.RangeArgs(1, 2),
-		Run:   runPullDiffCommand(&flags),
+		Long:  "pull commits or diff or all from ghost repo and apply them to working dir.  If you didn't specify any subcommand, this commands works as an alias for 'pull diff' command.  A token printed by 'push --token' can be given instead of hashes, and then all ghost branches in it are pulled.",
+		Args:  rangeArgsOrReference(&flags.name, 1, 2),
+		Run:   runPullDefaultCommand(&flags),
 	}
 	// command.PersistentFlags().BoolVarP(&flags.forceApply, "force", "f", true, "force apply pulled ghost branches to working dir")
+	command.PersistentFlags().StringVar(&flags.name, "name", "", "pull ghost branches named by 'push --name' instead of specifying hashes.")
+	command.PersistentFlags().StringVar(&flags.worktree, "worktree", "", "add a new worktree at this path checking out the base of ghost branches, and pull them there instead of the working dir.")
+	command.PersistentFlags().StringVar(&flags.branch, "branch", "", "create a branch with this name for the worktree added by --worktree (default to detached HEAD)")
+	command.PersistentFlags().BoolVar(&flags.autoStash, "autostash", false, "stash local changes before pulling and restore them after that.  without this, pull refuses to run on a working dir with local changes.")
+	command.PersistentFlags().BoolVar(&flags.check, "check", false, fmt.Sprintf("check whether ghost branches apply cleanly without modifying the working dir. results per file and the commit distance from the base of ghost branches are printed, and it exits with %d on conflicts.", checkConflictExitCode))
+	command.PersistentFlags().StringVar(&flags.checkBase, "check-base", "", "committish which ghost branches are checked against with --check (default to HEAD)")
+	command.PersistentFlags().BoolVar(&flags.threeWay, "3way", false, "apply ghost branches with 3-way merge ('git apply --3way' and 'git am --3way') when HEAD differs from their bases, leaving conflict markers on conflicts. results per file are printed.")
 
 	command.AddCommand(&cobra.Command{
 		Use:   "diff [diff-from-hash(default=HEAD)] [diff-hash]",
 		Short: "pull diff from ghost repo and apply it to working dir",
 		Long:  "pull diff from [diff-from-hash] to [diff-hash] from your ghost repo and apply it to working dir",
-		Args:  cobra.RangeArgs(1, 2),
+		Args:  rangeArgsOrReference(&flags.name, 1, 2),
 		Run:   runPullDiffCommand(&flags),
 	})
 	command.AddCommand(&cobra.Command{
 		Use:   "commits [from-hash(default=HEAD)] [to-hash]",
 		Short: "pull commits from ghost repo and apply it to working dir",
 		Long:  "pull commits from [from-hash] to [to-hash] from your ghost repo and apply it to working dir",
-		Args:  cobra.RangeArgs(1, 2),
+		Args:  rangeArgsOrReference(&flags.name, 1, 2),
 		Run:   runPullCommitsCommand(&flags),
 	})
 	command.AddCommand(&cobra.Command{
 		Use:   "all [from-hash(default=HEAD)] [to-hash] [diff-hash]",
 		Short: "pull both commits and diff from ghost repo and apply them to working dir sequentially",
 		Long:  "pull commits([from-hash]...[to-hash]) and diff([to-hash]...[diff-hash]) and apply them to working dir sequentially",
-		Args:  cobra.RangeArgs(2, 3),
+		Args:  rangeArgsOrReference(&flags.name, 2, 3),
 		Run:   runPullAllCommand(&flags),
 	})
 	return command
 }
 
+// runPullDefaultCommand works as 'pull diff', or pulls all ghost branches in a token
+func runPullDefaultCommand(flags *pullFlags) func(cmd *cobra.Command, args []string) {
+	runPullDiff := runPullDiffCommand(flags)
+	return func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 && types.IsToken(args[0]) {
+			pullToken(flags, args[0], "all")
+			return
+		}
+		runPullDiff(cmd, args)
+	}
+}
+
+// pullToken pulls ghost branches in token for subcommands of kind
+func pullToken(flags *pullFlags, arg, kind string) {
+	if err := flags.validate(); err != nil {
+		exitWithError(pullResultKind, err)
+	}
+	token, err := types.ParseToken(arg)
+	if err != nil {
+		exitWithError(pullResultKind, err)
+	}
+	commitsSpec, diffSpec, err := tokenSpecs(token, kind)
+	if err != nil {
+		exitWithError(pullResultKind, err)
+	}
+
+	options := ghost.PullOptions{
+		WorkingEnvSpec:         flags.workingEnvSpec(),
+		CommitsBranchSpec:      commitsSpec,
+		PullableDiffBranchSpec: diffSpec,
+	}
+
+	if err := flags.applyOptions(&options); err != nil {
+		exitWithError(pullResultKind, err)
+	}
+
+	result, err := ghost.Pull(options)
+	if err != nil {
+		exitWithError(pullResultKind, err)
+	}
+	printPullResult(flags, result)
+}
+
 type pullCommitsArg struct {
 	commitsFrom string
 	commitsTo   string
@@ -107,14 +205,27 @@ func (arg pullCommitsArg) validate() errors.GitGhostError {
 
 func runPullCommitsCommand(flags *pullFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 && types.IsToken(args[0]) {
+			pullToken(flags, args[0], "commits")
+			return
+		}
+		if err := flags.validate(); err != nil {
+			exitWithError(pullResultKind, err)
+		}
+		if flags.name != "" {
+			var err errors.GitGhostError
+			args, err = commitsArgsOfName(flags.name)
+			if err != nil {
+				exitWithError(pullResultKind, err)
+			}
+		}
 		arg := newPullCommitsArg(args)
 		if err := arg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pullResultKind, err)
 		}
 
 		options := ghost.PullOptions{
-			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			WorkingEnvSpec: flags.workingEnvSpec(),
 			CommitsBranchSpec: &types.CommitsBranchSpec{
 				Prefix:         globalOpts.ghostPrefix,
 				CommittishFrom: arg.commitsFrom,
@@ -123,11 +234,15 @@ func runPullCommitsCommand(flags *pullFlags) func(cmd *cobra.Command, args []str
 			// ForceApply: flags.forceApply,
 		}
 
-		err := ghost.Pull(options)
+		if err := flags.applyOptions(&options); err != nil {
+			exitWithError(pullResultKind, err)
+		}
+
+		result, err := ghost.Pull(options)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pullResultKind, err)
 		}
+		printPullResult(flags, result)
 	}
 }
 
@@ -168,14 +283,27 @@ func (arg pullDiffArg) validate() errors.GitGhostError {
 
 func runPullDiffCommand(flags *pullFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 && types.IsToken(args[0]) {
+			pullToken(flags, args[0], "diff")
+			return
+		}
+		if err := flags.validate(); err != nil {
+			exitWithError(pullResultKind, err)
+		}
+		if flags.name != "" {
+			var err errors.GitGhostError
+			args, err = diffArgsOfName(flags.name)
+			if err != nil {
+				exitWithError(pullResultKind, err)
+			}
+		}
 		arg := newPullDiffArg(args)
 		if err := arg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pullResultKind, err)
 		}
 
 		options := ghost.PullOptions{
-			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			WorkingEnvSpec: flags.workingEnvSpec(),
 			PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
 				Prefix:         globalOpts.ghostPrefix,
 				CommittishFrom: arg.diffFrom,
@@ -184,16 +312,34 @@ func runPullDiffCommand(flags *pullFlags) func(cmd *cobra.Command, args []string
 			// ForceApply: flags.forceApply,
 		}
 
-		err := ghost.Pull(options)
+		if err := flags.applyOptions(&options); err != nil {
+			exitWithError(pullResultKind, err)
+		}
+
+		result, err := ghost.Pull(options)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pullResultKind, err)
 		}
+		printPullResult(flags, result)
 	}
 }
 
 func runPullAllCommand(flags *pullFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 && types.IsToken(args[0]) {
+			pullToken(flags, args[0], "all")
+			return
+		}
+		if err := flags.validate(); err != nil {
+			exitWithError(pullResultKind, err)
+		}
+		if flags.name != "" {
+			var err errors.GitGhostError
+			args, err = allArgsOfName(flags.name)
+			if err != nil {
+				exitWithError(pullResultKind, err)
+			}
+		}
 		var pullCommitsArg pullCommitsArg
 		var pullDiffArg pullDiffArg
 
@@ -210,16 +356,14 @@ func runPullAllCommand(flags *pullFlags) func(cmd *cobra.Command, args []string)
 		}
 
 		if err := pullCommitsArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pullResultKind, err)
 		}
 		if err := pullDiffArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pullResultKind, err)
 		}
 
 		options := ghost.PullOptions{
-			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			WorkingEnvSpec: flags.workingEnvSpec(),
 			CommitsBranchSpec: &types.CommitsBranchSpec{
 				Prefix:         globalOpts.ghostPrefix,
 				CommittishFrom: pullCommitsArg.commitsFrom,
@@ -233,10 +377,51 @@ func runPullAllCommand(flags *pullFlags) func(cmd *cobra.Command, args []string)
 			// ForceApply: flags.forceApply,
 		}
 
-		err := ghost.Pull(options)
+		if err := flags.applyOptions(&options); err != nil {
+			exitWithError(pullResultKind, err)
+		}
+
+		result, err := ghost.Pull(options)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pullResultKind, err)
+		}
+		printPullResult(flags, result)
+	}
+}
+
+// printPullResult prints result as a document with --output, or results per file of 3-way merge.
+// It exits with an error if files are left with conflicts, or with checkConflictExitCode if they are found by --check.
+func printPullResult(flags *pullFlags, result *ghost.PullResult) {
+	if structuredOutput() {
+		printResult(pullResultKind, newPullOutput(result))
+	} else {
+		if d := result.Distance; d != nil {
+			fmt.Printf("%s is %d commit(s) ahead of and %d commit(s) behind %s\n", d.Base, d.Ahead, d.Behind, d.From)
+		}
+		for _, f := range result.Files {
+			fmt.Printf("%s\t%s\n", f.Status, f.Path)
+		}
+		if flags.check && result.DiffSkipped {
+			fmt.Println("diff is not checked because commits conflict")
+		}
+	}
+	if flags.check {
+		if result.Files.Conflicted() {
+			errors.LogErrorWithStack(errors.New("ghost branches conflict with the base checked against"))
+			os.Exit(checkConflictExitCode)
 		}
+		return
+	}
+	if result.DiffSkipped {
+		errors.LogErrorWithStack(errors.New("commits are applied with conflicts and diff is not applied. resolve them, run 'git am --continue' and pull the diff again"))
+		os.Exit(1)
+	}
+	if result.Files.Conflicted() {
+		errors.LogErrorWithStack(errors.New("ghost branches are applied with conflicts. resolve them in the working dir"))
+		os.Exit(1)
+	}
+	if len(result.StashConflicts) > 0 {
+		errors.LogErrorWithStack(errors.Errorf("local changes are restored with conflicts in %s. resolve them and drop the stash entry by 'git stash drop'", strings.Join(result.StashConflicts, ", ")))
+		os.Exit(1)
 	}
 }
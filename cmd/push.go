@@ -20,6 +20,7 @@ import (
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 
 	"github.com/spf13/cobra"
@@ -28,6 +29,59 @@ import (
 type pushFlags struct {
 	includedFilepaths []string
 	followSymlinks    bool
+	includeIgnored    bool
+	untracked         string
+	untrackedMaxSize  string
+	excludedPathspecs []string
+	separateStaged    bool
+	recurseSubmodules bool
+	labels            []string
+	message           string
+	name              string
+	token             bool
+}
+
+func (flags pushFlags) validate() errors.GitGhostError {
+	switch ghost.UntrackedFilesMode(flags.untracked) {
+	case ghost.UntrackedFilesNo, ghost.UntrackedFilesNormal, ghost.UntrackedFilesAll:
+	default:
+		return errors.Errorf("untracked must be one of no, normal or all: %s", flags.untracked)
+	}
+	if _, err := util.ParseSize(flags.untrackedMaxSize); err != nil {
+		return err
+	}
+	if _, err := parseLabels("label", flags.labels); err != nil {
+		return err
+	}
+	if flags.name != "" {
+		if _, err := types.NewAlias(globalOpts.ghostPrefix, flags.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateStash rejects flags of diff ghost branches which a stash entry can't be pushed with
+func (flags pushFlags) validateStash(cmd *cobra.Command) errors.GitGhostError {
+	for _, name := range []string{"include", "include-ignored", "follow-symlinks", "exclude", "separate-staged", "recurse-submodules", "untracked", "untracked-max-size"} {
+		if cmd.Flags().Changed(name) {
+			return errors.Errorf("%s can't be used with push stash", name)
+		}
+	}
+	return nil
+}
+
+func (flags pushFlags) applyMetadataOptions(options *ghost.PushOptions) {
+	// validated in pushFlags.validate
+	options.Labels, _ = parseLabels("label", flags.labels)
+	options.Message = flags.message
+	options.Name = flags.name
+}
+
+func (flags pushFlags) applyUntrackedOptions(options *ghost.PushOptions) {
+	options.UntrackedFiles = ghost.UntrackedFilesMode(flags.untracked)
+	// validated in pushFlags.validate
+	options.MaxUntrackedFileSize, _ = util.ParseSize(flags.untrackedMaxSize)
 }
 
 func init() {
@@ -39,10 +93,10 @@ func NewPushCommand() *cobra.Command {
 		flags pushFlags
 	)
 	command := &cobra.Command{
-		Use:   "push [from-hash(default=HEAD)]",
+		Use:   "push [from-hash(default=HEAD)] [-- pathspec...]",
 		Short: "push commits(hash1...hash2), diff(hash...current state) to your ghost repo",
 		Long:  "push commits or diff or all to your ghost repo.  If you didn't specify any subcommand, this commands works as an alias for 'push diff' command.",
-		Args:  cobra.RangeArgs(0, 1),
+		Args:  rangeArgsBeforeDash(0, 1),
 		Run:   runPushDiffCommand(&flags),
 	}
 	command.AddCommand(&cobra.Command{
@@ -53,26 +107,61 @@ func NewPushCommand() *cobra.Command {
 		Run:   runPushCommitsCommand(&flags),
 	})
 	command.AddCommand(&cobra.Command{
-		Use:   "diff [from-hash(default=HEAD)]",
+		Use:   "diff [from-hash(default=HEAD)] [-- pathspec...]",
 		Short: "push diff from a commit to current state of your working dir to your ghost repo",
-		Long:  "push diff from [from-hash] to current state of your working dir to your ghost repo.  please be noted that this pushes only diff, which means that it doesn't save any commits information.",
-		Args:  cobra.RangeArgs(0, 1),
+		Long:  "push diff from [from-hash] to current state of your working dir to your ghost repo.  please be noted that this pushes only diff, which means that it doesn't save any commits information.  If pathspecs are given after '--', the diff is limited to them.",
+		Args:  rangeArgsBeforeDash(0, 1),
 		Run:   runPushDiffCommand(&flags),
 	})
 	command.AddCommand(&cobra.Command{
-		Use:   "all [commits-from-hash] [diff-from-hash(default=HEAD)]",
+		Use:   "stash [stash(default=stash@{0})]",
+		Short: "push a stash entry as diff to your ghost repo",
+		Long:  "push a stash entry as diff from its base commit to your ghost repo without popping it.  untracked files in the stash entry are also pushed.",
+		Args:  cobra.RangeArgs(0, 1),
+		Run:   runPushStashCommand(&flags),
+	})
+	command.AddCommand(&cobra.Command{
+		Use:   "all [commits-from-hash] [diff-from-hash(default=HEAD)] [-- pathspec...]",
 		Short: "push both commits and diff to your ghost repo",
-		Long:  "push both commits([commits-from-hash]...[diff-from-hash]) and diff([diff-from-hash]...current state) to your ghost repo",
-		Args:  cobra.RangeArgs(1, 2),
+		Long:  "push both commits([commits-from-hash]...[diff-from-hash]) and diff([diff-from-hash]...current state) to your ghost repo.  If pathspecs are given after '--', the diff is limited to them.",
+		Args:  rangeArgsBeforeDash(1, 2),
 		Run:   runPushAllCommand(&flags),
 	})
 
-	command.PersistentFlags().StringSliceVarP(&flags.includedFilepaths, "include", "I", []string{}, "include a non-indexed file, this flag can be repeated to specify multiple files.")
+	command.PersistentFlags().StringSliceVarP(&flags.includedFilepaths, "include", "I", []string{}, "include a non-indexed file, directory or glob pattern (e.g. 'notebooks/**/*.ipynb'), this flag can be repeated to specify multiple files.")
+	command.PersistentFlags().BoolVar(&flags.includeIgnored, "include-ignored", false, "include files ignored by .gitignore when expanding directories and glob patterns of --include.")
 	command.PersistentFlags().BoolVar(&flags.followSymlinks, "follow-symlinks", false, "follow symlinks inside the repository.")
+	command.PersistentFlags().StringArrayVar(&flags.excludedPathspecs, "exclude", []string{}, "exclude files matching a pathspec relative to the top of the working tree from diff, this flag can be repeated. pathspecs listed in .ghostignore at the top are also excluded.")
+	command.PersistentFlags().BoolVar(&flags.separateStaged, "separate-staged", false, "store staged and unstaged changes separately so that pull restores the staging state.")
+	command.PersistentFlags().BoolVar(&flags.recurseSubmodules, "recurse-submodules", false, "push changes in submodules as their own ghost branches linked to the diff, which are applied on pull.  submodules not in the base commit are skipped.")
+	command.PersistentFlags().StringVar(&flags.untracked, "untracked", string(ghost.UntrackedFilesNo), "include untracked files in diff. 'normal' (default when no value is given) includes files not ignored by .gitignore, 'all' includes ignored files too.")
+	command.PersistentFlags().Lookup("untracked").NoOptDefVal = string(ghost.UntrackedFilesNormal)
+	command.PersistentFlags().StringVar(&flags.untrackedMaxSize, "untracked-max-size", "1M", "skip untracked files larger than this size (e.g. 512K, 10M). 0 means unlimited.")
+	command.PersistentFlags().StringArrayVar(&flags.labels, "label", []string{}, "attach a label in key=value form to ghost branches, this flag can be repeated. labels can be used by 'list --selector'. they are not recorded on ghost branches which already exist.")
+	command.PersistentFlags().StringVar(&flags.name, "name", "", "name resultant ghost branches (e.g. alice/exp-42) so that pull, show and delete accept the name instead of hashes.  pushing under the same name again updates it and keeps its history.")
+	command.PersistentFlags().BoolVar(&flags.token, "token", false, "print a single token referring to pushed ghost branches instead of hashes, which pull, show and restore accept.")
+	command.PersistentFlags().StringVarP(&flags.message, "message", "m", "", "attach a note to ghost branches, which is also used as their commit messages.")
 
 	return command
 }
 
+// splitArgsAtDash splits args into ones before "--" and ones after it
+func splitArgsAtDash(cmd *cobra.Command, args []string) ([]string, []string) {
+	dash := cmd.ArgsLenAtDash()
+	if dash < 0 {
+		return args, []string{}
+	}
+	return args[:dash], args[dash:]
+}
+
+// rangeArgsBeforeDash is cobra.RangeArgs which does not count args after "--"
+func rangeArgsBeforeDash(min, max int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		argsBeforeDash, _ := splitArgsAtDash(cmd, args)
+		return cobra.RangeArgs(min, max)(cmd, argsBeforeDash)
+	}
+}
+
 type pushCommitsArg struct {
 	commitsFrom string
 	commitsTo   string
@@ -112,8 +201,10 @@ func runPushCommitsCommand(flags *pushFlags) func(cmd *cobra.Command, args []str
 	return func(cmd *cobra.Command, args []string) {
 		pushArg := newPushCommitsArg(args)
 		if err := pushArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pushResultKind, err)
+		}
+		if err := flags.validate(); err != nil {
+			exitWithError(pushResultKind, err)
 		}
 		options := ghost.PushOptions{
 			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
@@ -123,11 +214,22 @@ func runPushCommitsCommand(flags *pushFlags) func(cmd *cobra.Command, args []str
 				CommittishTo:   pushArg.commitsTo,
 			},
 		}
+		flags.applyMetadataOptions(&options)
 
 		result, err := ghost.Push(options)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pushResultKind, err)
+		}
+		printUnannotatedBranches(result)
+		if structuredOutput() {
+			printPushOutput(flags, result)
+			return
+		}
+		if flags.token {
+			if err := printPushToken(result); err != nil {
+				exitWithError(pushResultKind, err)
+			}
+			return
 		}
 
 		if result.CommitsBranch != nil {
@@ -166,10 +268,13 @@ func (arg pushDiffArg) validate() errors.GitGhostError {
 
 func runPushDiffCommand(flags *pushFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		args, pathspecs := splitArgsAtDash(cmd, args)
 		pushArg := newPushDiffArg(args)
 		if err := pushArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pushResultKind, err)
+		}
+		if err := flags.validate(); err != nil {
+			exitWithError(pushResultKind, err)
 		}
 		options := ghost.PushOptions{
 			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
@@ -178,13 +283,103 @@ func runPushDiffCommand(flags *pushFlags) func(cmd *cobra.Command, args []string
 				CommittishFrom:    pushArg.diffFrom,
 				IncludedFilepaths: flags.includedFilepaths,
 				FollowSymlinks:    flags.followSymlinks,
+				IncludeIgnored:    flags.includeIgnored,
+				ExcludedPathspecs: flags.excludedPathspecs,
+				Pathspecs:         pathspecs,
+				SeparateStaged:    flags.separateStaged,
+				RecurseSubmodules: flags.recurseSubmodules,
 			},
 		}
+		flags.applyUntrackedOptions(&options)
+		flags.applyMetadataOptions(&options)
 
 		result, err := ghost.Push(options)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pushResultKind, err)
+		}
+		printUnannotatedBranches(result)
+		if structuredOutput() {
+			printPushOutput(flags, result)
+			return
+		}
+		printUntrackedFilesSummary(result)
+		if flags.token {
+			if err := printPushToken(result); err != nil {
+				exitWithError(pushResultKind, err)
+			}
+			return
+		}
+
+		if result.DiffBranch != nil {
+			fmt.Printf(
+				"%s %s",
+				result.DiffBranch.CommitHashFrom,
+				result.DiffBranch.DiffHash,
+			)
+			fmt.Print("\n")
+		}
+	}
+}
+
+type pushStashArg struct {
+	stash string
+}
+
+func newPushStashArg(args []string) pushStashArg {
+	pushStashArg := pushStashArg{
+		stash: "stash@{0}",
+	}
+	if len(args) >= 1 {
+		pushStashArg.stash = args[0]
+	}
+	return pushStashArg
+}
+
+func (arg pushStashArg) validate() errors.GitGhostError {
+	if err := nonEmpty("stash", arg.stash); err != nil {
+		return err
+	}
+	if err := isValidCommittish("stash", arg.stash); err != nil {
+		return err
+	}
+	return nil
+}
+
+func runPushStashCommand(flags *pushFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if err := flags.validate(); err != nil {
+			exitWithError(pushResultKind, err)
+		}
+		if err := flags.validateStash(cmd); err != nil {
+			exitWithError(pushResultKind, err)
+		}
+		pushArg := newPushStashArg(args)
+		if err := pushArg.validate(); err != nil {
+			exitWithError(pushResultKind, err)
+		}
+		options := ghost.PushOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			StashBranchSpec: &types.StashBranchSpec{
+				Prefix: globalOpts.ghostPrefix,
+				Stash:  pushArg.stash,
+			},
+		}
+		flags.applyMetadataOptions(&options)
+
+		result, err := ghost.Push(options)
+		if err != nil {
+			exitWithError(pushResultKind, err)
+		}
+		printUnannotatedBranches(result)
+		if structuredOutput() {
+			printPushOutput(flags, result)
+			return
+		}
+		if flags.token {
+			if err := printPushToken(result); err != nil {
+				exitWithError(pushResultKind, err)
+			}
+			return
 		}
 
 		if result.DiffBranch != nil {
@@ -200,16 +395,18 @@ func runPushDiffCommand(flags *pushFlags) func(cmd *cobra.Command, args []string
 
 func runPushAllCommand(flags *pushFlags) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
+		args, pathspecs := splitArgsAtDash(cmd, args)
 		pushCommitsArg := newPushCommitsArg(args[0:1])
 		if err := pushCommitsArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pushResultKind, err)
 		}
 
 		pushDiffArg := newPushDiffArg(args[1:])
 		if err := pushDiffArg.validate(); err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pushResultKind, err)
+		}
+		if err := flags.validate(); err != nil {
+			exitWithError(pushResultKind, err)
 		}
 
 		options := ghost.PushOptions{
@@ -224,13 +421,31 @@ func runPushAllCommand(flags *pushFlags) func(cmd *cobra.Command, args []string)
 				CommittishFrom:    pushDiffArg.diffFrom,
 				IncludedFilepaths: flags.includedFilepaths,
 				FollowSymlinks:    flags.followSymlinks,
+				IncludeIgnored:    flags.includeIgnored,
+				ExcludedPathspecs: flags.excludedPathspecs,
+				Pathspecs:         pathspecs,
+				SeparateStaged:    flags.separateStaged,
+				RecurseSubmodules: flags.recurseSubmodules,
 			},
 		}
+		flags.applyUntrackedOptions(&options)
+		flags.applyMetadataOptions(&options)
 
 		result, err := ghost.Push(options)
 		if err != nil {
-			errors.LogErrorWithStack(err)
-			os.Exit(1)
+			exitWithError(pushResultKind, err)
+		}
+		printUnannotatedBranches(result)
+		if structuredOutput() {
+			printPushOutput(flags, result)
+			return
+		}
+		printUntrackedFilesSummary(result)
+		if flags.token {
+			if err := printPushToken(result); err != nil {
+				exitWithError(pushResultKind, err)
+			}
+			return
 		}
 
 		if result.CommitsBranch != nil {
@@ -254,3 +469,32 @@ func runPushAllCommand(flags *pushFlags) func(cmd *cobra.Command, args []string)
 		}
 	}
 }
+
+// printUnannotatedBranches warns that labels and message are not recorded on ghost branches which already exist
+func printUnannotatedBranches(result *ghost.PushResult) {
+	for _, name := range result.UnannotatedBranches {
+		fmt.Fprintf(os.Stderr, "labels and message are not recorded on existing ghost branch: %s\n", name)
+	}
+}
+
+func printUntrackedFilesSummary(result *ghost.PushResult) {
+	for _, f := range result.UntrackedFiles {
+		fmt.Fprintf(os.Stderr, "included untracked file: %s\n", f)
+	}
+	for _, f := range result.SkippedUntrackedFiles {
+		fmt.Fprintf(os.Stderr, "skipped too large untracked file: %s\n", f)
+	}
+}
+
+// printPushOutput prints result as a document, which contains a token referring to ghost branches with --token
+func printPushOutput(flags *pushFlags, result *ghost.PushResult) {
+	token := ""
+	if flags.token {
+		var err errors.GitGhostError
+		token, err = pushToken(result)
+		if err != nil {
+			exitWithError(pushResultKind, err)
+		}
+	}
+	printResult(pushResultKind, newPushOutput(result, token))
+}
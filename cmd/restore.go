@@ -0,0 +1,137 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(NewRestoreCommand())
+}
+
+type restoreFlags struct {
+	sourceRepo string
+	dest       string
+}
+
+func NewRestoreCommand() *cobra.Command {
+	var (
+		flags restoreFlags
+	)
+	command := &cobra.Command{
+		Use:   "restore [token] | restore --source-repo [url] [remote-base] [local-base] [diff-hash]",
+		Short: "restore the source repository with ghost branches referred by a token or hashes",
+		Long:  "check out [remote-base] of the source repository into a new directory and pull commits([remote-base]...[local-base]) and diff([local-base]...[diff-hash]) there, as 'pull all' does.  Only [remote-base] is fetched from the source repository if it allows.  A token printed by 'push --token' can be given instead of hashes, which contains the source repository too.",
+		Args:  restoreArgs,
+		Run:   runRestoreCommand(&flags),
+	}
+	command.Flags().StringVar(&flags.sourceRepo, "source-repo", "", "git remote url of the source repository (default to the one in the token)")
+	command.Flags().StringVar(&flags.dest, "dest", "", "directory which the source repository is restored into. it must not exist or be empty. (default to src-dir)")
+	return command
+}
+
+// restoreArgs accepts a token or hashes
+func restoreArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 1 && types.IsToken(args[0]) {
+		return nil
+	}
+	return cobra.ExactArgs(3)(cmd, args)
+}
+
+func runRestoreCommand(flags *restoreFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		options := ghost.RestoreOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+		}
+		if flags.dest != "" {
+			options.SrcDir = flags.dest
+		}
+
+		token, err := tokenArg(args)
+		if err != nil {
+			exitWithError(restoreResultKind, err)
+		}
+		if token != nil {
+			options.SourceRepo = token.SourceRepo
+			options.Base = token.Base()
+			options.CommitsBranchSpec = token.CommitsBranchSpec()
+			options.PullableDiffBranchSpec = token.PullableDiffBranchSpec()
+		} else {
+			arg := newRestoreArg(args)
+			if err := arg.validate(); err != nil {
+				exitWithError(restoreResultKind, err)
+			}
+			options.Base = arg.remoteBase
+			if arg.remoteBase != arg.localBase {
+				options.CommitsBranchSpec = &types.CommitsBranchSpec{
+					Prefix:         globalOpts.ghostPrefix,
+					CommittishFrom: arg.remoteBase,
+					CommittishTo:   arg.localBase,
+				}
+			}
+			options.PullableDiffBranchSpec = &types.PullableDiffBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: arg.localBase,
+				DiffHash:       arg.diffHash,
+			}
+		}
+		if flags.sourceRepo != "" {
+			options.SourceRepo = flags.sourceRepo
+		}
+		if err := nonEmpty("source-repo", options.SourceRepo); err != nil {
+			exitWithError(restoreResultKind, err)
+		}
+
+		result, err := ghost.Restore(options)
+		if err != nil {
+			exitWithError(restoreResultKind, err)
+		}
+		if structuredOutput() {
+			printResult(restoreResultKind, newPullOutput(result))
+		}
+	}
+}
+
+type restoreArg struct {
+	remoteBase string
+	localBase  string
+	diffHash   string
+}
+
+func newRestoreArg(args []string) restoreArg {
+	return restoreArg{
+		remoteBase: args[0],
+		localBase:  args[1],
+		diffHash:   args[2],
+	}
+}
+
+func (arg restoreArg) validate() errors.GitGhostError {
+	if err := nonEmpty("remote-base", arg.remoteBase); err != nil {
+		return err
+	}
+	if err := nonEmpty("local-base", arg.localBase); err != nil {
+		return err
+	}
+	if err := nonEmpty("diff-hash", arg.diffHash); err != nil {
+		return err
+	}
+	return nil
+}
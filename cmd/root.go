@@ -17,6 +17,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
@@ -27,18 +28,26 @@ import (
 )
 
 type globalFlags struct {
-	srcDir       string
-	ghostWorkDir string
-	ghostPrefix  string
-	ghostRepo    string
-	verbose      int
+	srcDir        string
+	ghostWorkDir  string
+	ghostPrefix   string
+	ghostRepo     string
+	ghostCache    bool
+	ghostCacheDir string
+	output        string
+	verbose       int
 }
 
 func (gf globalFlags) WorkingEnvSpec() types.WorkingEnvSpec {
 	workingEnvSpec := types.WorkingEnvSpec{
-		SrcDir:          gf.srcDir,
-		GhostWorkingDir: gf.ghostWorkDir,
-		GhostRepo:       gf.ghostRepo,
+		SrcDir:           gf.srcDir,
+		GhostWorkingDir:  gf.ghostWorkDir,
+		GhostRepo:        gf.ghostRepo,
+		GitGhostVersion:  Version,
+		GitGhostRevision: Revision,
+	}
+	if gf.ghostCache {
+		workingEnvSpec.GhostCacheDir = gf.ghostCacheDir
 	}
 	userName, userEmail, err := git.GetUserConfig(globalOpts.srcDir)
 	if err == nil {
@@ -60,37 +69,59 @@ var RootCmd = &cobra.Command{
 	Short:         "git-ghost",
 	SilenceErrors: false,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		if cmd.Use == "version" {
+		err := preRun(cmd, args)
+		if err == nil {
 			return nil
 		}
-		err := validateEnvironment()
-		if err != nil {
-			return err
-		}
-		err = globalOpts.SetDefaults()
-		if err != nil {
-			return err
-		}
-		err = globalOpts.Validate()
-		if err != nil {
-			return err
-		}
-		switch globalOpts.verbose {
-		case 0:
-			log.SetLevel(log.ErrorLevel)
-		case 1:
-			log.SetLevel(log.InfoLevel)
-		case 2:
-			log.SetLevel(log.DebugLevel)
-		case 3:
-			log.SetLevel(log.TraceLevel)
-		default:
-			log.SetLevel(log.TraceLevel)
+		if kind := resultKindOf(cmd); kind != "" {
+			// list has its own output flag shadowing the global one
+			switch output := cmd.Flags().Lookup("output").Value.String(); output {
+			case outputFormatJSON, outputFormatYAML:
+				globalOpts.output = output
+			}
+			if structuredOutput() {
+				exitWithError(kind, err)
+			}
 		}
-		return nil
+		return err
 	},
 }
 
+func preRun(cmd *cobra.Command, args []string) errors.GitGhostError {
+	if cmd.Use == "version" {
+		return nil
+	}
+	err := validateEnvironment()
+	if err != nil {
+		return err
+	}
+	err = globalOpts.applyToken(args)
+	if err != nil {
+		return err
+	}
+	err = globalOpts.SetDefaults()
+	if err != nil {
+		return err
+	}
+	err = globalOpts.Validate()
+	if err != nil {
+		return err
+	}
+	switch globalOpts.verbose {
+	case 0:
+		log.SetLevel(log.ErrorLevel)
+	case 1:
+		log.SetLevel(log.InfoLevel)
+	case 2:
+		log.SetLevel(log.DebugLevel)
+	case 3:
+		log.SetLevel(log.TraceLevel)
+	default:
+		log.SetLevel(log.TraceLevel)
+	}
+	return nil
+}
+
 var globalOpts globalFlags
 
 func init() {
@@ -99,6 +130,9 @@ func init() {
 	RootCmd.PersistentFlags().StringVar(&globalOpts.ghostWorkDir, "ghost-working-dir", "", "local root directory for git-ghost interacting with ghost repository (default to a temporary directory)")
 	RootCmd.PersistentFlags().StringVar(&globalOpts.ghostPrefix, "ghost-prefix", "", "prefix of ghost branch name (default to GIT_GHOST_PREFIX env, or ghost)")
 	RootCmd.PersistentFlags().StringVar(&globalOpts.ghostRepo, "ghost-repo", "", "git remote url for ghosts repository (default to GIT_GHOST_REPO env)")
+	RootCmd.PersistentFlags().BoolVar(&globalOpts.ghostCache, "ghost-cache", false, "fetch ghost branches via a local mirror of ghost repository which is shared among git-ghost processes")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.ghostCacheDir, "ghost-cache-dir", "", "local root directory for mirrors of ghost repositories used with --ghost-cache (default to git-ghost directory in the user cache directory, e.g. ~/.cache/git-ghost)")
+	RootCmd.PersistentFlags().StringVarP(&globalOpts.output, "output", "o", "", "print results and errors as documents of a versioned schema for scripts. One of: json|yaml")
 	RootCmd.PersistentFlags().CountVarP(&globalOpts.verbose, "verbose", "v", "verbose mode. (1: info, 2: debug, 3: trace)")
 	RootCmd.AddCommand(versionCmd)
 }
@@ -131,6 +165,12 @@ func (flags *globalFlags) SetDefaults() errors.GitGhostError {
 	if globalOpts.ghostWorkDir == "" {
 		globalOpts.ghostWorkDir = os.TempDir()
 	}
+	if globalOpts.ghostCacheDir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err == nil {
+			globalOpts.ghostCacheDir = filepath.Join(cacheDir, "git-ghost")
+		}
+	}
 	if globalOpts.ghostPrefix == "" {
 		ghostPrefixEnv := os.Getenv("GIT_GHOST_PREFIX")
 		if ghostPrefixEnv == "" {
@@ -152,11 +192,17 @@ func (flags *globalFlags) Validate() errors.GitGhostError {
 	if err != nil {
 		return errors.Errorf("ghost-working-dir is not found (value: %v)", flags.ghostWorkDir)
 	}
+	if flags.ghostCache && flags.ghostCacheDir == "" {
+		return errors.New("ghost-cache-dir must be specified")
+	}
 	if flags.ghostPrefix == "" {
 		return errors.New("ghost-prefix must be specified")
 	}
 	if flags.ghostRepo == "" {
 		return errors.New("ghost-repo must be specified")
 	}
+	if err := validateOutputFormat(flags.output); err != nil {
+		return err
+	}
 	return nil
 }
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"bytes"
 	"os"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost"
@@ -29,38 +30,82 @@ func init() {
 	RootCmd.AddCommand(NewShowCommand())
 }
 
+type showFlags struct {
+	manifest bool
+	name     string
+}
+
 func NewShowCommand() *cobra.Command {
+	var (
+		flags showFlags
+	)
+
 	command := &cobra.Command{
 		Use:   "show [from-hash(default=HEAD)] [diff-hash]",
 		Short: "show commits(hash1...hash2), diff(hash...current state) in ghost repo",
-		Long:  "show commits or diff or all from ghost repo.  If you didn't specify any subcommand, this commands works as an alias for 'show diff' command.",
-		Args:  cobra.RangeArgs(1, 2),
-		Run:   runShowDiffCommand,
+		Long:  "show commits or diff or all from ghost repo.  If you didn't specify any subcommand, this commands works as an alias for 'show diff' command.  A token printed by 'push --token' can be given instead of hashes, and then all ghost branches in it are shown.",
+		Args:  rangeArgsOrReference(&flags.name, 1, 2),
+		Run:   runShowDefaultCommand(&flags),
 	}
 	command.AddCommand(&cobra.Command{
 		Use:   "diff [diff-from-hash(default=HEAD)] [diff-hash]",
 		Short: "show diff in ghost repo ",
 		Long:  "show diff from [diff-from-hash] to [diff-hash] in ghost repo",
-		Args:  cobra.RangeArgs(1, 2),
-		Run:   runShowDiffCommand,
+		Args:  rangeArgsOrReference(&flags.name, 1, 2),
+		Run:   runShowDiffCommand(&flags),
 	})
 	command.AddCommand(&cobra.Command{
 		Use:   "commits [from-hash(default=HEAD)] [to-hash]",
 		Short: "show commits in ghost repo",
 		Long:  "show commits from [from-hash] to [to-hash] in ghost repo",
-		Args:  cobra.RangeArgs(1, 2),
-		Run:   runShowCommitsCommand,
+		Args:  rangeArgsOrReference(&flags.name, 1, 2),
+		Run:   runShowCommitsCommand(&flags),
 	})
 	command.AddCommand(&cobra.Command{
 		Use:   "all [from-hash(default=HEAD)] [to-hash] [diff-hash]",
 		Short: "show both commits and diff in ghost repo",
 		Long:  "show commits([from-hash]...[to-hash]) and diff([to-hash]...[diff-hash]) in ghost repo",
-		Args:  cobra.RangeArgs(2, 3),
-		Run:   runShowAllCommand,
+		Args:  rangeArgsOrReference(&flags.name, 2, 3),
+		Run:   runShowAllCommand(&flags),
 	})
+	command.PersistentFlags().StringVar(&flags.name, "name", "", "show ghost branches named by 'push --name' instead of specifying hashes.")
+	command.PersistentFlags().BoolVar(&flags.manifest, "manifest", false, "show manifests recorded in ghost branches in JSON instead of their contents.")
 	return command
 }
 
+// runShowDefaultCommand works as 'show diff', or shows all ghost branches in a token
+func runShowDefaultCommand(flags *showFlags) func(cmd *cobra.Command, args []string) {
+	runShowDiff := runShowDiffCommand(flags)
+	return func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 && types.IsToken(args[0]) {
+			showToken(flags, args[0], "all")
+			return
+		}
+		runShowDiff(cmd, args)
+	}
+}
+
+// showToken shows ghost branches in token for subcommands of kind
+func showToken(flags *showFlags, arg, kind string) {
+	token, err := types.ParseToken(arg)
+	if err != nil {
+		exitWithError(showResultKind, err)
+	}
+	commitsSpec, diffSpec, err := tokenSpecs(token, kind)
+	if err != nil {
+		exitWithError(showResultKind, err)
+	}
+
+	options := ghost.ShowOptions{
+		WorkingEnvSpec:         globalOpts.WorkingEnvSpec(),
+		CommitsBranchSpec:      commitsSpec,
+		PullableDiffBranchSpec: diffSpec,
+		Manifest:               flags.manifest,
+	}
+
+	runShow(options)
+}
+
 type showCommitsArg struct {
 	commitsFrom string
 	commitsTo   string
@@ -96,27 +141,35 @@ func (arg showCommitsArg) validate() errors.GitGhostError {
 	return nil
 }
 
-func runShowCommitsCommand(cmd *cobra.Command, args []string) {
-	arg := newShowCommitsArg(args)
-	if err := arg.validate(); err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
-	}
+func runShowCommitsCommand(flags *showFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 && types.IsToken(args[0]) {
+			showToken(flags, args[0], "commits")
+			return
+		}
+		if flags.name != "" {
+			var err errors.GitGhostError
+			args, err = commitsArgsOfName(flags.name)
+			if err != nil {
+				exitWithError(showResultKind, err)
+			}
+		}
+		arg := newShowCommitsArg(args)
+		if err := arg.validate(); err != nil {
+			exitWithError(showResultKind, err)
+		}
 
-	options := ghost.ShowOptions{
-		WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
-		CommitsBranchSpec: &types.CommitsBranchSpec{
-			Prefix:         globalOpts.ghostPrefix,
-			CommittishFrom: arg.commitsFrom,
-			CommittishTo:   arg.commitsTo,
-		},
-		Writer: os.Stdout,
-	}
+		options := ghost.ShowOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			CommitsBranchSpec: &types.CommitsBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: arg.commitsFrom,
+				CommittishTo:   arg.commitsTo,
+			},
+			Manifest: flags.manifest,
+		}
 
-	err := ghost.Show(options)
-	if err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
+		runShow(options)
 	}
 }
 
@@ -155,73 +208,104 @@ func (arg showDiffArg) validate() errors.GitGhostError {
 	return nil
 }
 
-func runShowDiffCommand(cmd *cobra.Command, args []string) {
-	arg := newShowDiffArg(args)
-	if err := arg.validate(); err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
-	}
+func runShowDiffCommand(flags *showFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 && types.IsToken(args[0]) {
+			showToken(flags, args[0], "diff")
+			return
+		}
+		if flags.name != "" {
+			var err errors.GitGhostError
+			args, err = diffArgsOfName(flags.name)
+			if err != nil {
+				exitWithError(showResultKind, err)
+			}
+		}
+		arg := newShowDiffArg(args)
+		if err := arg.validate(); err != nil {
+			exitWithError(showResultKind, err)
+		}
 
-	options := ghost.ShowOptions{
-		WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
-		PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
-			Prefix:         globalOpts.ghostPrefix,
-			CommittishFrom: arg.diffFrom,
-			DiffHash:       arg.diffHash,
-		},
-		Writer: os.Stdout,
-	}
+		options := ghost.ShowOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: arg.diffFrom,
+				DiffHash:       arg.diffHash,
+			},
+			Manifest: flags.manifest,
+		}
 
-	err := ghost.Show(options)
-	if err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
+		runShow(options)
 	}
 }
 
-func runShowAllCommand(cmd *cobra.Command, args []string) {
-	var showCommitsArg showCommitsArg
-	var showDiffArg showDiffArg
+func runShowAllCommand(flags *showFlags) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 && types.IsToken(args[0]) {
+			showToken(flags, args[0], "all")
+			return
+		}
+		if flags.name != "" {
+			var err errors.GitGhostError
+			args, err = allArgsOfName(flags.name)
+			if err != nil {
+				exitWithError(showResultKind, err)
+			}
+		}
+		var showCommitsArg showCommitsArg
+		var showDiffArg showDiffArg
 
-	switch len(args) {
-	case 3:
-		showCommitsArg = newShowCommitsArg(args[0:2])
-		showDiffArg = newShowDiffArg(args[1:])
-	case 2:
-		showCommitsArg = newShowCommitsArg(args[0:1])
-		showDiffArg = newShowDiffArg(args)
-	default:
-		log.Error(cmd.Args(cmd, args))
-		os.Exit(1)
-	}
+		switch len(args) {
+		case 3:
+			showCommitsArg = newShowCommitsArg(args[0:2])
+			showDiffArg = newShowDiffArg(args[1:])
+		case 2:
+			showCommitsArg = newShowCommitsArg(args[0:1])
+			showDiffArg = newShowDiffArg(args)
+		default:
+			log.Error(cmd.Args(cmd, args))
+			os.Exit(1)
+		}
 
-	if err := showCommitsArg.validate(); err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
-	}
-	if err := showDiffArg.validate(); err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
-	}
+		if err := showCommitsArg.validate(); err != nil {
+			exitWithError(showResultKind, err)
+		}
+		if err := showDiffArg.validate(); err != nil {
+			exitWithError(showResultKind, err)
+		}
 
-	options := ghost.ShowOptions{
-		WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
-		CommitsBranchSpec: &types.CommitsBranchSpec{
-			Prefix:         globalOpts.ghostPrefix,
-			CommittishFrom: showCommitsArg.commitsFrom,
-			CommittishTo:   showCommitsArg.commitsTo,
-		},
-		PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
-			Prefix:         globalOpts.ghostPrefix,
-			CommittishFrom: showDiffArg.diffFrom,
-			DiffHash:       showDiffArg.diffHash,
-		},
-		Writer: os.Stdout,
+		options := ghost.ShowOptions{
+			WorkingEnvSpec: globalOpts.WorkingEnvSpec(),
+			CommitsBranchSpec: &types.CommitsBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: showCommitsArg.commitsFrom,
+				CommittishTo:   showCommitsArg.commitsTo,
+			},
+			PullableDiffBranchSpec: &types.PullableDiffBranchSpec{
+				Prefix:         globalOpts.ghostPrefix,
+				CommittishFrom: showDiffArg.diffFrom,
+				DiffHash:       showDiffArg.diffHash,
+			},
+			Manifest: flags.manifest,
+		}
+
+		runShow(options)
 	}
+}
 
+// runShow writes contents of ghost branches to stdout, or prints them as a document with --output
+func runShow(options ghost.ShowOptions) {
+	var content bytes.Buffer
+	options.Writer = os.Stdout
+	if structuredOutput() {
+		options.Writer = &content
+	}
 	err := ghost.Show(options)
 	if err != nil {
-		errors.LogErrorWithStack(err)
-		os.Exit(1)
+		exitWithError(showResultKind, err)
+	}
+	if structuredOutput() {
+		printResult(showResultKind, showOutput{Content: content.String()})
 	}
 }
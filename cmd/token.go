@@ -0,0 +1,102 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenArg returns the token if args consist of a token only
+func tokenArg(args []string) (*types.Token, errors.GitGhostError) {
+	if len(args) != 1 || !types.IsToken(args[0]) {
+		return nil, nil
+	}
+	return types.ParseToken(args[0])
+}
+
+// tokenSpecs returns specs of ghost branches in token for subcommands of kind.
+// kind is one of "commits", "diff" and "all", and "all" returns specs of all ghost branches in token.
+func tokenSpecs(token *types.Token, kind string) (*types.CommitsBranchSpec, *types.PullableDiffBranchSpec, errors.GitGhostError) {
+	commitsSpec := token.CommitsBranchSpec()
+	diffSpec := token.PullableDiffBranchSpec()
+	switch kind {
+	case "commits":
+		if commitsSpec == nil {
+			return nil, nil, errors.New("token has no commits")
+		}
+		return commitsSpec, nil, nil
+	case "diff":
+		if diffSpec == nil {
+			return nil, nil, errors.New("token has no diff")
+		}
+		return nil, diffSpec, nil
+	}
+	return commitsSpec, diffSpec, nil
+}
+
+// applyToken makes the ghost repository and the prefix in the token given as args take precedence
+func (flags *globalFlags) applyToken(args []string) errors.GitGhostError {
+	token, err := tokenArg(args)
+	if err != nil || token == nil {
+		return err
+	}
+	flags.ghostRepo = token.GhostRepo
+	flags.ghostPrefix = token.Prefix
+	return nil
+}
+
+// printPushToken prints a token referring to ghost branches in result
+func printPushToken(result *ghost.PushResult) errors.GitGhostError {
+	token, err := pushToken(result)
+	if err != nil || token == "" {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
+
+// pushToken returns a token referring to ghost branches in result, or an empty string if there is nothing pushed
+func pushToken(result *ghost.PushResult) (string, errors.GitGhostError) {
+	branches := []types.GhostBranch{}
+	if result.CommitsBranch != nil {
+		branches = append(branches, result.CommitsBranch)
+	}
+	if result.DiffBranch != nil {
+		branches = append(branches, result.DiffBranch)
+	}
+	if len(branches) == 0 {
+		return "", nil
+	}
+	remotes, err := git.ListRemoteURLs(globalOpts.srcDir)
+	if err != nil {
+		return "", err
+	}
+	sourceRepo, ok := remotes[git.ORIGIN]
+	if !ok {
+		log.Warnf("source repository is not recorded in the token because %s has no %s remote", globalOpts.srcDir, git.ORIGIN)
+	}
+	token, err := types.NewToken(globalOpts.ghostRepo, sourceRepo, branches...)
+	if err != nil {
+		return "", err
+	}
+	return token.String(), nil
+}
@@ -15,6 +15,8 @@
 package cmd
 
 import (
+	"strings"
+
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 )
@@ -33,3 +35,16 @@ func isValidCommittish(name, committish string) errors.GitGhostError {
 	}
 	return nil
 }
+
+// parseLabels parses key=value pairs into a map
+func parseLabels(name string, labels []string) (map[string]string, errors.GitGhostError) {
+	parsed := map[string]string{}
+	for _, label := range labels {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.Errorf("%s must be in key=value form: %s", name, label)
+		}
+		parsed[kv[0]] = kv[1]
+	}
+	return parsed, nil
+}
@@ -40,18 +40,13 @@ if [[ -z "$IMAGE_TAG" ]]; then
   IMAGE_TAG="latest"
 fi
 
-GIT_REPO=`git remote get-url origin`
-GIT_COMMIT_HASH=`git rev-parse HEAD`
 git diff --exit-code HEAD > /dev/null && (echo "Make some local modification to try the example."; exit 1)
-DIFF_HASH=`git ghost push $GIT_COMMIT_HASH | tail -n 1 | cut -f 2 -d " "`
+GHOST_TOKEN=`git ghost push --token HEAD`
 
 cat $DIR/pod.yaml | \
 sed "s|{{IMAGE_PREFIX}}|$IMAGE_PREFIX|g" | \
 sed "s|{{IMAGE_TAG}}|$IMAGE_TAG|g" | \
-sed "s|{{GIT_REPO}}|$GIT_REPO|g" | \
-sed "s|{{GIT_GHOST_REPO}}|$GIT_GHOST_REPO|g" | \
-sed "s|{{GIT_COMMIT_HASH}}|$GIT_COMMIT_HASH|g" | \
-sed "s|{{DIFF_HASH}}|$DIFF_HASH|g" | kubectl create -f -
+sed "s|{{GHOST_TOKEN}}|${GHOST_TOKEN//&/\\&}|g" | kubectl create -f -
 
 POD_NAME=`kubectl get pod -l app=git-ghost-example --sort-by=.metadata.creationTimestamp --no-headers -o custom-columns=:metadata.name | tail -n 1`
 
@@ -26,11 +26,17 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// branchBatchSize is the max number of branches passed to one git command
+const branchBatchSize = 100
+
 // DeleteOptions represents arg for Delete func
 type DeleteOptions struct {
 	types.WorkingEnvSpec
 	*types.ListCommitsBranchSpec
 	*types.ListDiffBranchSpec
+	// Alias is deleted together with ghost branches if set.
+	// It is updated instead if it points to other ghost branches still alive.
+	Alias  *types.Alias
 	Dryrun bool
 }
 
@@ -70,39 +76,113 @@ func Delete(options DeleteOptions) (*DeleteResult, errors.GitGhostError) {
 	}
 	defer util.LogDeferredGitGhostError(workingEnv.Clean)
 
-	deleteBranches := func(branches []types.GhostBranch, dryrun bool) errors.GitGhostError {
-		if len(branches) == 0 {
-			return nil
+	err = deleteResultBranches(*workingEnv, &res, options.Dryrun)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if options.Alias != nil {
+		err = deleteAlias(*workingEnv, *options.Alias, &res, options.Dryrun)
+		if err != nil {
+			return nil, errors.WithStack(err)
 		}
-		var branchNames []string
-		for _, branch := range branches {
-			branchNames = append(branchNames, branch.BranchName())
+	}
+
+	return &res, nil
+}
+
+// deleteAlias deletes alias if all ghost branches which it points to are deleted.
+// Otherwise it updates alias to point only to alive ones so that its history is kept.
+func deleteAlias(we types.WorkingEnv, alias types.Alias, res *DeleteResult, dryrun bool) errors.GitGhostError {
+	deleted := map[string]bool{}
+	if res.CommitsBranches != nil {
+		for _, branch := range *res.CommitsBranches {
+			deleted[branch.BranchName()] = true
+		}
+	}
+	if res.DiffBranches != nil {
+		for _, branch := range *res.DiffBranches {
+			deleted[branch.BranchName()] = true
 		}
+	}
+	remaining := []string{}
+	for _, name := range alias.BranchNames() {
+		if !deleted[name] {
+			remaining = append(remaining, name)
+		}
+	}
+	alive, err := git.ListRemoteBranchNames(we.GhostRepo, remaining)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	alias = alias.Only(alive...)
+
+	if len(alias.BranchNames()) > 0 {
 		log.WithFields(log.Fields{
-			"branches": branchNames,
-		}).Info("Delete branch")
+			"name":     alias.Name,
+			"branches": alias.BranchNames(),
+		}).Info("Update name to alive branches")
 		if dryrun {
 			return nil
 		}
-		err := git.DeleteRemoteBranches(workingEnv.GhostDir, branchNames...)
-		return errors.WithStack(err)
+		return alias.Update(we)
 	}
+	log.WithFields(log.Fields{
+		"name": alias.Name,
+	}).Info("Delete name")
+	if dryrun {
+		return nil
+	}
+	return alias.Delete(we)
+}
 
+func deleteResultBranches(we types.WorkingEnv, res *DeleteResult, dryrun bool) errors.GitGhostError {
 	if res.CommitsBranches != nil {
-		err := deleteBranches(res.CommitsBranches.AsGhostBranches(), options.Dryrun)
+		err := deleteBranches(we, res.CommitsBranches.AsGhostBranches(), dryrun)
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return errors.WithStack(err)
 		}
 	}
 
 	if res.DiffBranches != nil {
-		err := deleteBranches(res.DiffBranches.AsGhostBranches(), options.Dryrun)
+		err := deleteBranches(we, res.DiffBranches.AsGhostBranches(), dryrun)
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return errors.WithStack(err)
 		}
 	}
 
-	return &res, nil
+	return nil
+}
+
+func deleteBranches(we types.WorkingEnv, branches []types.GhostBranch, dryrun bool) errors.GitGhostError {
+	return forEachBranchNamesBatch(branches, func(branchNames []string) errors.GitGhostError {
+		log.WithFields(log.Fields{
+			"branches": branchNames,
+		}).Info("Delete branch")
+		if dryrun {
+			return nil
+		}
+		return git.DeleteRemoteBranches(we.GhostDir, branchNames...)
+	})
+}
+
+// forEachBranchNamesBatch calls f with names of branches split into batches of branchBatchSize
+func forEachBranchNamesBatch(branches []types.GhostBranch, f func(branchNames []string) errors.GitGhostError) errors.GitGhostError {
+	for start := 0; start < len(branches); start += branchBatchSize {
+		end := start + branchBatchSize
+		if end > len(branches) {
+			end = len(branches)
+		}
+		branchNames := make([]string, 0, end-start)
+		for _, branch := range branches[start:end] {
+			branchNames = append(branchNames, branch.BranchName())
+		}
+		err := f(branchNames)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
 }
 
 // PrettyString pretty prints ListResult
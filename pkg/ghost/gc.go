@@ -0,0 +1,352 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GCOptions represents arg for GC func
+//
+// Ghost branches which match all the specified conditions are collected.
+type GCOptions struct {
+	types.WorkingEnvSpec
+	// Prefix is a prefix of ghost branches to be collected
+	Prefix string
+	// OlderThan is a minimum age of ghost branches to be collected (nil means no condition on ages)
+	OlderThan *time.Duration
+	// Unreachable enables collecting ghost branches whose base commits are unreachable from SourceRemote
+	Unreachable bool
+	// SourceRemote is a remote name of the source repository in SrcDir
+	SourceRemote string
+	// MergedInto is a committish in SrcDir. Ghost branches whose patches are already contained in it are collected if specified.
+	MergedInto string
+	Dryrun     bool
+}
+
+// branchNameSet is a set of ghost branch names matching a gc condition
+type branchNameSet map[string]bool
+
+// GC deletes ghost branches matching conditions from ghost repo and returns deleted branches
+func GC(options GCOptions) (*DeleteResult, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("gc command with")
+
+	if options.OlderThan == nil && !options.Unreachable && options.MergedInto == "" {
+		return nil, errors.New("no gc condition is specified")
+	}
+
+	commitsBranches, err := (&types.ListCommitsBranchSpec{Prefix: options.Prefix}).GetBranches(options.GhostRepo)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	diffBranches, err := (&types.ListDiffBranchSpec{Prefix: options.Prefix}).GetBranches(options.GhostRepo)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	workingEnv, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredGitGhostError(workingEnv.Clean)
+
+	if options.OlderThan != nil || options.MergedInto != "" {
+		branches := append(commitsBranches.AsGhostBranches(), diffBranches.AsGhostBranches()...)
+		err := forEachBranchNamesBatch(branches, func(branchNames []string) errors.GitGhostError {
+			return workingEnv.FetchBranches(branchNames...)
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	var conditions []branchNameSet
+	if options.OlderThan != nil {
+		stale, err := staleBranchNames(*workingEnv, *options.OlderThan)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		conditions = append(conditions, stale)
+	}
+	if options.Unreachable {
+		unreachable, err := unreachableBranchNames(options.SrcDir, options.SourceRemote, commitsBranches, diffBranches)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		conditions = append(conditions, unreachable)
+	}
+	if options.MergedInto != "" {
+		merged, err := mergedBranchNames(*workingEnv, options.MergedInto, commitsBranches, diffBranches)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		conditions = append(conditions, merged)
+	}
+	matchesAll := func(branch types.GhostBranch) bool {
+		for _, names := range conditions {
+			if !names[branch.BranchName()] {
+				return false
+			}
+		}
+		return true
+	}
+
+	collectedCommitsBranches := types.CommitsBranches{}
+	for _, branch := range commitsBranches {
+		if matchesAll(branch) {
+			collectedCommitsBranches = append(collectedCommitsBranches, branch)
+		}
+	}
+	collectedDiffBranches := types.DiffBranches{}
+	for _, branch := range diffBranches {
+		if matchesAll(branch) {
+			collectedDiffBranches = append(collectedDiffBranches, branch)
+		}
+	}
+
+	res := DeleteResult{
+		CommitsBranches: &collectedCommitsBranches,
+		DiffBranches:    &collectedDiffBranches,
+	}
+	err = deleteResultBranches(*workingEnv, &res, options.Dryrun)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = pruneAliases(*workingEnv, options.Prefix, &res, options.Dryrun)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &res, nil
+}
+
+// pruneAliases updates or deletes aliases pointing to ghost branches deleted in res so that they never point to missing ones
+func pruneAliases(we types.WorkingEnv, prefix string, res *DeleteResult, dryrun bool) errors.GitGhostError {
+	deleted := map[string]bool{}
+	for _, branch := range *res.CommitsBranches {
+		deleted[branch.BranchName()] = true
+	}
+	for _, branch := range *res.DiffBranches {
+		deleted[branch.BranchName()] = true
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+	aliases, err := types.ListAliases(we, prefix)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, alias := range aliases {
+		for _, name := range alias.BranchNames() {
+			if deleted[name] {
+				err := deleteAlias(we, alias, res, dryrun)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// staleBranchNames returns names of ghost branches whose commits are older than olderThan
+func staleBranchNames(we types.WorkingEnv, olderThan time.Duration) (branchNameSet, errors.GitGhostError) {
+	commitTimes, err := git.ListRemoteBranchCommitTimes(we.GhostDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	threshold := time.Now().Add(-olderThan)
+	names := branchNameSet{}
+	for name, commitTime := range commitTimes {
+		if !commitTime.After(threshold) {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+// unreachableBranchNames returns names of ghost branches whose base commits are unreachable from remote of the source repository.
+//
+// A diff branch is still reachable when its base commit is carried by a reachable commits branch.
+func unreachableBranchNames(srcDir, remote string, commitsBranches types.CommitsBranches, diffBranches types.DiffBranches) (branchNameSet, errors.GitGhostError) {
+	// Commits missing in a shallow clone may still be reachable from remote
+	shallow, err := git.IsShallowRepository(srcDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if shallow {
+		return nil, errors.Errorf("unreachable can't be used in a shallow repository %s", srcDir)
+	}
+	// Heads are fetched by hash, which also works in a single branch clone and leaves remote tracking branches as they are
+	heads, err := git.ListRemoteHeadCommits(srcDir, remote)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	missingHeads := []string{}
+	for _, head := range heads {
+		exists, err := git.CommittishExists(srcDir, head)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !exists {
+			missingHeads = append(missingHeads, head)
+		}
+	}
+	if len(missingHeads) > 0 {
+		err = git.FetchRemoteCommits(srcDir, remote, missingHeads...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	reachableCache := map[string]bool{}
+	isReachable := func(commit string) (bool, errors.GitGhostError) {
+		if reachable, ok := reachableCache[commit]; ok {
+			return reachable, nil
+		}
+		reachable, err := git.IsCommitReachableFromAny(srcDir, commit, heads)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		reachableCache[commit] = reachable
+		return reachable, nil
+	}
+
+	names := branchNameSet{}
+	carriedCommits := map[string]bool{}
+	for _, branch := range commitsBranches {
+		reachable, err := isReachable(branch.CommitHashFrom)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !reachable {
+			names[branch.BranchName()] = true
+			continue
+		}
+		carriedCommits[branch.CommitHashTo] = true
+	}
+	for _, branch := range diffBranches {
+		if carriedCommits[branch.CommitHashFrom] {
+			continue
+		}
+		reachable, err := isReachable(branch.CommitHashFrom)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !reachable {
+			names[branch.BranchName()] = true
+		}
+	}
+	for name := range names {
+		log.WithFields(log.Fields{
+			"branch": name,
+			"remote": remote,
+		}).Info("base commit is unreachable")
+	}
+	return names, nil
+}
+
+// mergedBranchNames returns names of ghost branches whose patches are already contained in ref of the source repository.
+//
+// Patches are compared with non-merge commits in <base commit>..ref by their `git patch-id`.
+// A ghost branch is regarded as merged only if all of its patch ids are found.
+func mergedBranchNames(we types.WorkingEnv, ref string, commitsBranches types.CommitsBranches, diffBranches types.DiffBranches) (branchNameSet, errors.GitGhostError) {
+	err := git.ValidateCommittish(we.SrcDir, ref)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	upstreamCache := map[string]map[string]bool{}
+	upstreamPatchIDs := func(base string, diffOpts ...string) (map[string]bool, errors.GitGhostError) {
+		key := strings.Join(append([]string{base}, diffOpts...), " ")
+		if patchIDs, ok := upstreamCache[key]; ok {
+			return patchIDs, nil
+		}
+		patchIDs := map[string]bool{}
+		exists, err := git.CommittishExists(we.SrcDir, base)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if exists {
+			ids, err := git.GetCommitsPatchIDs(we.SrcDir, base, ref, diffOpts...)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			for _, id := range ids {
+				patchIDs[id] = true
+			}
+		}
+		upstreamCache[key] = patchIDs
+		return patchIDs, nil
+	}
+	isMerged := func(branch types.GhostBranch, base string, diffOpts ...string) (bool, errors.GitGhostError) {
+		object := fmt.Sprintf("refs/remotes/%s/%s:%s", git.ORIGIN, branch.BranchName(), branch.FileName())
+		patchIDs, err := git.GetObjectPatchIDs(we.GhostDir, object)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		if len(patchIDs) == 0 {
+			return false, nil
+		}
+		upstream, err := upstreamPatchIDs(base, diffOpts...)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		for _, id := range patchIDs {
+			if !upstream[id] {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	names := branchNameSet{}
+	for _, branch := range commitsBranches {
+		merged, err := isMerged(branch, branch.CommitHashFrom)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if merged {
+			names[branch.BranchName()] = true
+		}
+	}
+	for _, branch := range diffBranches {
+		// local mod patches are created with --patience
+		merged, err := isMerged(branch, branch.CommitHashFrom, "--patience")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if merged {
+			names[branch.BranchName()] = true
+		}
+	}
+	for name := range names {
+		log.WithFields(log.Fields{
+			"branch": name,
+			"ref":    ref,
+		}).Info("patches are already merged")
+	}
+	return names, nil
+}
@@ -31,3 +31,14 @@ func ValidateRemoteBranchExistence(repo, branch string) (bool, errors.GitGhostEr
 	}
 	return string(output) != "", nil
 }
+
+// ValidateRemoteRefExistence checks repo has ref or not.
+func ValidateRemoteRefExistence(repo, ref string) (bool, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "ls-remote", "--refs", repo, ref),
+	)
+	if err != nil {
+		return false, err
+	}
+	return string(output) != "", nil
+}
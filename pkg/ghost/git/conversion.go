@@ -15,6 +15,7 @@
 package git
 
 import (
+	"fmt"
 	"os/exec"
 	"strings"
 
@@ -32,3 +33,14 @@ func ResolveCommittish(dir, committish string) (string, errors.GitGhostError) {
 	}
 	return strings.TrimRight(string(commit), "\r\n"), nil
 }
+
+// ResolveSubmoduleCommit resolves a commit of the submodule at path recorded in commit on dir
+func ResolveSubmoduleCommit(dir, commit, path string) (string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-parse", "--verify", "-q", fmt.Sprintf("%s:%s", commit, path)),
+	)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(output), "\r\n"), nil
+}
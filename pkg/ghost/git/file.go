@@ -15,9 +15,13 @@
 package git
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
@@ -66,15 +70,229 @@ func ApplyDiffBundleFile(dir, filepath string) errors.GitGhostError {
 	return errors.WithStack(errs)
 }
 
+// ApplyDiffBundleFileThreeWay apply a patch file created in CreateDiffBundleFile with 3-way merge.
+// Unlike ApplyDiffBundleFile, 'git am' is not aborted on conflicts so that they can be resolved and continued.
+// It returns files which are merged by 3-way merge.
+func ApplyDiffBundleFileThreeWay(dir, filepath string) ([]string, errors.GitGhostError) {
+	stdout := bytes.NewBufferString("")
+	cmd := exec.Command("git", "-C", dir, "am", "--3way", filepath)
+	// Messages of merged files are parsed
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	cmd.Stdout = stdout
+	err := util.JustRunCmd(cmd)
+
+	merged := []string{}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if strings.HasPrefix(line, "Auto-merging ") {
+			merged = append(merged, strings.TrimPrefix(line, "Auto-merging "))
+		}
+	}
+	if err == nil {
+		return merged, nil
+	}
+	unmerged, ggerr := ListUnmergedFiles(dir)
+	if ggerr == nil && len(unmerged) > 0 {
+		log.WithFields(log.Fields{
+			"srcDir":   dir,
+			"filepath": filepath,
+			"unmerged": unmerged,
+		}).Info("apply('git am --3way') stopped with conflicts")
+		return merged, nil
+	}
+	log.WithFields(log.Fields{
+		"srcDir":   dir,
+		"filepath": filepath,
+		"error":    err.Error(),
+	}).Info("apply('git am --3way') failed. aborting.")
+	resetErr := util.JustRunCmd(
+		exec.Command("git", "-C", dir, "am", "--abort"),
+	)
+	if resetErr != nil {
+		return nil, errors.WithStack(multierror.Append(err, resetErr))
+	}
+	return nil, err
+}
+
+// ListUnappliedBundleFiles returns files changed by patches which a stopped 'git am' session in dir has not applied yet.
+// The patch which the session stopped at is regarded as applied since its changes are left in dir.
+// It returns an empty list if 'git am' is not in progress.
+func ListUnappliedBundleFiles(dir string) ([]string, errors.GitGhostError) {
+	output, ggerr := util.JustOutputCmd(exec.Command("git", "-C", dir, "rev-parse", "--git-path", "rebase-apply"))
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	amDir := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(amDir) {
+		amDir = filepath.Join(dir, amDir)
+	}
+	next, err := readPatchNumber(filepath.Join(amDir, "next"))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	last, err := readPatchNumber(filepath.Join(amDir, "last"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	files := []string{}
+	seen := map[string]bool{}
+	for i := next + 1; i <= last; i++ {
+		patchFiles, ggerr := ListPatchFiles(dir, filepath.Join(amDir, fmt.Sprintf("%04d", i)))
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		for _, f := range patchFiles {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	return files, nil
+}
+
+// readPatchNumber reads a patch number saved by 'git am' in path
+func readPatchNumber(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+// canonicalDiffArgs returns arguments of git diff in dir whose output does not depend on
+// user's configurations, so that the same working tree always produces the same patch.
+func canonicalDiffArgs(dir string, args ...string) []string {
+	return append([]string{
+		"-C", dir,
+		"-c", "core.quotepath=true",
+		"-c", "diff.renames=true",
+		"-c", "diff.suppressBlankEmpty=false",
+		"diff", "--patience", "--binary",
+		"--no-color", "--no-ext-diff", "--no-textconv", "--no-relative",
+		"--src-prefix=a/", "--dst-prefix=b/",
+		"--unified=3", "--inter-hunk-context=0", "--indent-heuristic", "-O" + os.DevNull,
+	}, args...)
+}
+
+// excludePathspecs converts pathspecs to ones excluding matched paths.
+// They are relative to the top of the working tree, where .ghostignore is, rather than dir.
+func excludePathspecs(pathspecs []string) []string {
+	excludes := make([]string, 0, len(pathspecs))
+	for _, p := range pathspecs {
+		switch {
+		case strings.HasPrefix(p, ":("):
+			// long form magic, e.g. ":(glob)**/*.ipynb"
+			excludes = append(excludes, ":(exclude,top,"+p[2:])
+		case strings.HasPrefix(p, ":"):
+			// short form magic, e.g. ":/path"
+			excludes = append(excludes, ":!"+p[1:])
+		default:
+			excludes = append(excludes, ":(exclude,top)"+p)
+		}
+	}
+	return excludes
+}
+
+// FilterFiles returns files in dir which match pathspecs and do not match excludedPathspecs.
+// Empty pathspecs match all files.
+func FilterFiles(dir string, files, pathspecs, excludedPathspecs []string) ([]string, errors.GitGhostError) {
+	if len(files) == 0 || (len(pathspecs) == 0 && len(excludedPathspecs) == 0) {
+		return files, nil
+	}
+	args := []string{"-C", dir, "ls-files", "--cached", "--others", "-z", "--"}
+	if len(pathspecs) > 0 {
+		args = append(args, pathspecs...)
+	} else {
+		// Files are not given as pathspecs because there can be too many of them for the command line
+		args = append(args, ":(top)")
+	}
+	args = append(args, excludePathspecs(excludedPathspecs)...)
+	output, err := util.JustOutputCmd(exec.Command("git", args...))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	matched := map[string]bool{}
+	for _, f := range strings.Split(string(output), "\x00") {
+		matched[f] = true
+	}
+	filtered := []string{}
+	for _, f := range files {
+		if matched[filepath.ToSlash(f)] {
+			filtered = append(filtered, f)
+		}
+	}
+	return filtered, nil
+}
+
 // CreateDiffPatchFile creates a diff from committish to current working state of `dir` and save it to filepath
-func CreateDiffPatchFile(dir, filepath, committish string) errors.GitGhostError {
+// The diff is limited to pathspecs if any, and paths matching excludedPathspecs are omitted from it.
+func CreateDiffPatchFile(dir, filepath, committish string, pathspecs, excludedPathspecs []string, diffOpts ...string) errors.GitGhostError {
+	return createDiffPatchFile(dir, filepath, diffOpts, []string{committish}, pathspecs, excludedPathspecs)
+}
+
+// CreateIndexDiffPatchFile creates a diff from committish to the index of `dir` and save it to filepath
+// The diff is limited to pathspecs if any, and paths matching excludedPathspecs are omitted from it.
+func CreateIndexDiffPatchFile(dir, filepath, committish string, pathspecs, excludedPathspecs []string, diffOpts ...string) errors.GitGhostError {
+	return createDiffPatchFile(dir, filepath, diffOpts, []string{"--cached", committish}, pathspecs, excludedPathspecs)
+}
+
+// CreateStashDiffPatchFile creates a diff of a stash entry from its base commit and save it to filepath
+// Untracked files stashed with it are also included as new files.
+func CreateStashDiffPatchFile(dir, filepath, stash string) errors.GitGhostError {
 	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 	defer util.LogDeferredError(f.Close)
 
-	cmd := exec.Command("git", "-C", dir, "diff", "--patience", "--binary", committish)
+	cmd := exec.Command("git", canonicalDiffArgs(dir, stash+"^1", stash)...)
+	cmd.Stdout = f
+	ggerr := util.JustRunCmd(cmd)
+	if ggerr != nil {
+		return ggerr
+	}
+
+	// The third parent of a stash entry is a root commit containing untracked files
+	untrackedCommit := stash + "^3"
+	_, ggerr = util.JustOutputCmd(exec.Command("git", "-C", dir, "rev-parse", "-q", "--verify", untrackedCommit+"^{commit}"))
+	if ggerr != nil {
+		if util.GetExitCode(ggerr.Cause()) == 1 {
+			// the stash entry has no untracked files
+			return nil
+		}
+		return ggerr
+	}
+	emptyTree, ggerr := util.JustOutputCmd(exec.Command("git", "-C", dir, "hash-object", "-t", "tree", os.DevNull))
+	if ggerr != nil {
+		return ggerr
+	}
+	cmd = exec.Command("git", canonicalDiffArgs(dir, strings.TrimSpace(string(emptyTree)), untrackedCommit)...)
+	cmd.Stdout = f
+	return util.JustRunCmd(cmd)
+}
+
+func createDiffPatchFile(dir, filepath string, diffOpts, revisions, pathspecs, excludedPathspecs []string) errors.GitGhostError {
+	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer util.LogDeferredError(f.Close)
+
+	args := append(append([]string{}, diffOpts...), revisions...)
+	if len(pathspecs) > 0 || len(excludedPathspecs) > 0 {
+		args = append(args, "--")
+		if len(pathspecs) > 0 {
+			args = append(args, pathspecs...)
+		} else {
+			// The whole working tree even if dir is a subdirectory
+			args = append(args, ":(top)")
+		}
+		args = append(args, excludePathspecs(excludedPathspecs)...)
+	}
+	cmd := exec.Command("git", canonicalDiffArgs(dir, args...)...)
 	cmd.Stdout = f
 	return util.JustRunCmd(cmd)
 }
@@ -89,7 +307,7 @@ func AppendNonIndexedDiffFiles(dir, filepath string, nonIndexedFilepaths []strin
 
 	var errs error
 	for _, p := range nonIndexedFilepaths {
-		cmd := exec.Command("git", "-C", dir, "diff", "--patience", "--binary", "--no-index", os.DevNull, p)
+		cmd := exec.Command("git", canonicalDiffArgs(dir, "--no-index", os.DevNull, p)...)
 		cmd.Stdout = f
 		ggerr := util.JustRunCmd(cmd)
 		if ggerr != nil {
@@ -105,6 +323,15 @@ func AppendNonIndexedDiffFiles(dir, filepath string, nonIndexedFilepaths []strin
 
 // ApplyDiffPatchFile apply a diff file created by CreateDiffPatchFile
 func ApplyDiffPatchFile(dir, filepath string) errors.GitGhostError {
+	return applyDiffPatchFile(dir, filepath)
+}
+
+// ApplyDiffPatchFileToIndex apply a diff file created by CreateIndexDiffPatchFile only to the index
+func ApplyDiffPatchFileToIndex(dir, filepath string) errors.GitGhostError {
+	return applyDiffPatchFile(dir, filepath, "--cached")
+}
+
+func applyDiffPatchFile(dir, filepath string, opts ...string) errors.GitGhostError {
 	// Handle empty patch
 	fi, err := os.Stat(filepath)
 	if err != nil {
@@ -118,7 +345,82 @@ func ApplyDiffPatchFile(dir, filepath string) errors.GitGhostError {
 			})).Info("ignore empty patch")
 		return nil
 	}
+	args := append([]string{"-C", dir, "apply"}, opts...)
 	return util.JustRunCmd(
-		exec.Command("git", "-C", dir, "apply", filepath),
+		exec.Command("git", append(args, filepath)...),
 	)
 }
+
+// ApplyDiffPatchFileThreeWay apply a diff file created by CreateDiffPatchFile with 3-way merge.
+// Conflicts are left in dir with conflict markers instead of failing.
+func ApplyDiffPatchFileThreeWay(dir, filepath string) errors.GitGhostError {
+	err := applyDiffPatchFile(dir, filepath, "--3way")
+	if err == nil {
+		return nil
+	}
+	unmerged, ggerr := ListUnmergedFiles(dir)
+	if ggerr != nil || len(unmerged) == 0 {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"srcDir":   dir,
+		"filepath": filepath,
+		"unmerged": unmerged,
+	}).Info("apply('git apply --3way') left conflicts")
+	return nil
+}
+
+// CheckDiffPatchFile returns nil if a diff file can be applied to dir without 3-way merge.
+// The check is limited to files matching includes if given.
+func CheckDiffPatchFile(dir, filepath string, includes ...string) errors.GitGhostError {
+	opts := []string{"--check"}
+	for _, include := range includes {
+		opts = append(opts, "--include="+include)
+	}
+	return applyDiffPatchFile(dir, filepath, opts...)
+}
+
+// CheckDiffPatchFileToIndex returns nil if a diff file created by CreateIndexDiffPatchFile can be applied to the index of dir
+func CheckDiffPatchFileToIndex(dir, filepath string) errors.GitGhostError {
+	return applyDiffPatchFile(dir, filepath, "--cached", "--check")
+}
+
+// GetObjectPatchIDs returns stable patch ids of patches contained in a blob object on dir
+func GetObjectPatchIDs(dir, object string) ([]string, errors.GitGhostError) {
+	return getPatchIDs(dir, exec.Command("git", "-C", dir, "cat-file", "-p", object))
+}
+
+// GetCommitsPatchIDs returns stable patch ids of non-merge commits in fromCommittish..toCommittish on dir
+func GetCommitsPatchIDs(dir, fromCommittish, toCommittish string, diffOpts ...string) ([]string, errors.GitGhostError) {
+	args := []string{"-C", dir, "log", "-p", "--binary", "--no-merges"}
+	args = append(args, diffOpts...)
+	args = append(args, fmt.Sprintf("%s..%s", fromCommittish, toCommittish))
+	return getPatchIDs(dir, exec.Command("git", args...))
+}
+
+func getPatchIDs(dir string, patchCmd *exec.Cmd) ([]string, errors.GitGhostError) {
+	patch, err := util.JustOutputCmd(patchCmd)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cmd := exec.Command("git", "-C", dir, "patch-id", "--stable")
+	cmd.Stdin = bytes.NewReader(patch)
+	output, err := util.JustOutputCmd(cmd)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	lines := strings.Split(string(output), "\n")
+	patchIDs := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) != 2 {
+			return nil, errors.Errorf("Got unexpected line: %s", line)
+		}
+		patchIDs = append(patchIDs, tokens[0])
+	}
+	return patchIDs, nil
+}
@@ -17,7 +17,9 @@ package git
 import (
 	"fmt"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
@@ -59,3 +61,207 @@ func ListRemoteBranchNames(repo string, branchnames []string) ([]string, errors.
 	}
 	return branchNames, nil
 }
+
+// ListRemoteRefNames returns names of refs in repo which start with prefix
+func ListRemoteRefNames(repo, prefix string) ([]string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(exec.Command("git", "ls-remote", "-q", "--refs", repo))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	refNames := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "" {
+			continue
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) != 2 {
+			return nil, errors.Errorf("Got unexpected line: %s", line)
+		}
+		if strings.HasPrefix(tokens[1], prefix) {
+			refNames = append(refNames, tokens[1])
+		}
+	}
+	return refNames, nil
+}
+
+// ListRemoteHeadCommits returns commits at the heads of branches of remote on dir
+func ListRemoteHeadCommits(dir, remote string) ([]string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(exec.Command("git", "-C", dir, "ls-remote", "-q", "--heads", "--refs", remote))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	commits := []string{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "" {
+			continue
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) != 2 {
+			return nil, errors.Errorf("Got unexpected line: %s", line)
+		}
+		if !seen[tokens[0]] {
+			seen[tokens[0]] = true
+			commits = append(commits, tokens[0])
+		}
+	}
+	return commits, nil
+}
+
+// ListRemoteBranchCommitTimes returns committer dates of remote tracking branches of its origin in dir
+func ListRemoteBranchCommitTimes(dir string) (map[string]time.Time, errors.GitGhostError) {
+	refPrefix := fmt.Sprintf("refs/remotes/%s/", ORIGIN)
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "for-each-ref", "--format=%(refname) %(committerdate:unix)", refPrefix),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	times := map[string]time.Time{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "" {
+			continue
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) != 2 {
+			return nil, errors.Errorf("Got unexpected line: %s", line)
+		}
+		unix, err := strconv.ParseInt(tokens[1], 10, 64)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		times[strings.TrimPrefix(tokens[0], refPrefix)] = time.Unix(unix, 0)
+	}
+	return times, nil
+}
+
+// ListBranchNames returns local branch names on dir
+func ListBranchNames(dir string) ([]string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "for-each-ref", "--format=%(refname:lstrip=2)", "refs/heads/"),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	branchNames := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if line != "" {
+			branchNames = append(branchNames, line)
+		}
+	}
+	return branchNames, nil
+}
+
+// ListUntrackedFiles returns untracked files on dir matching pathspecs.
+// Files ignored by .gitignore are excluded unless includeIgnored is true.
+func ListUntrackedFiles(dir string, includeIgnored bool, pathspecs ...string) ([]string, errors.GitGhostError) {
+	args := []string{"-C", dir, "ls-files", "--others", "-z"}
+	if !includeIgnored {
+		args = append(args, "--exclude-standard")
+	}
+	args = append(args, "--")
+	args = append(args, pathspecs...)
+	output, err := util.JustOutputCmd(exec.Command("git", args...))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	files := []string{}
+	for _, f := range strings.Split(string(output), "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// ListSubmodulePaths returns paths of submodules recorded in the index of dir
+func ListSubmodulePaths(dir string) ([]string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(exec.Command("git", "-C", dir, "ls-files", "--stage", "-z"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	paths := []string{}
+	for _, entry := range strings.Split(string(output), "\x00") {
+		// "<mode> <object> <stage>\t<path>"
+		if !strings.HasPrefix(entry, "160000 ") {
+			continue
+		}
+		tokens := strings.SplitN(entry, "\t", 2)
+		if len(tokens) != 2 {
+			return nil, errors.Errorf("Got unexpected entry: %s", entry)
+		}
+		paths = append(paths, tokens[1])
+	}
+	return paths, nil
+}
+
+// ListUnmergedFiles returns files with conflicts on dir
+func ListUnmergedFiles(dir string) ([]string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(exec.Command("git", "-C", dir, "diff", "--name-only", "--diff-filter=U", "-z"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	files := []string{}
+	for _, f := range strings.Split(string(output), "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// ListPatchFiles returns files changed by patches in filepath in order of appearance.
+// Renamed files are listed by their new paths.
+func ListPatchFiles(dir, filepath string) ([]string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(exec.Command("git", "-C", dir, "apply", "--numstat", "-z", filepath))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	files := []string{}
+	seen := map[string]bool{}
+	entries := strings.Split(string(output), "\x00")
+	for i := 0; i < len(entries); i++ {
+		// "<added>\t<deleted>\t<path>", or "<added>\t<deleted>\t" followed by "<old path>" and "<new path>" for renames
+		tokens := strings.SplitN(entries[i], "\t", 3)
+		if len(tokens) != 3 {
+			continue
+		}
+		f := tokens[2]
+		if f == "" {
+			if i+2 >= len(entries) {
+				return nil, errors.Errorf("Got unexpected entry: %s", entries[i])
+			}
+			f = entries[i+2]
+			i += 2
+		}
+		if !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// CountDivergentCommits returns the numbers of commits reachable only from left and only from right on dir
+func CountDivergentCommits(dir, left, right string) (int, int, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-list", "--left-right", "--count", fmt.Sprintf("%s...%s", left, right)),
+	)
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+	tokens := strings.Fields(string(output))
+	if len(tokens) != 2 {
+		return 0, 0, errors.Errorf("Got unexpected output: %s", string(output))
+	}
+	leftCount, cerr := strconv.Atoi(tokens[0])
+	if cerr != nil {
+		return 0, 0, errors.WithStack(cerr)
+	}
+	rightCount, cerr := strconv.Atoi(tokens[1])
+	if cerr != nil {
+		return 0, 0, errors.WithStack(cerr)
+	}
+	return leftCount, rightCount, nil
+}
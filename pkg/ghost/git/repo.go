@@ -15,30 +15,124 @@
 package git
 
 import (
+	"bytes"
 	"fmt"
 	"os/exec"
+	"sort"
 	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 
 	gherrors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 )
 
 var (
 	ORIGIN string = "origin"
 )
 
-// InitializeGitDir clone repo to dir.
-// if you set empty branchname, it will checkout default branch of repo.
-func InitializeGitDir(dir, repo, branch string) errors.GitGhostError {
-	args := []string{"clone", "-q", "-o", ORIGIN}
-	if branch != "" {
-		args = append(args, "-b", branch)
+// InitializeGitDir initializes an empty git directory on dir whose origin is repo.
+// Nothing is fetched from repo. Use FetchBranches to fetch branches you need.
+func InitializeGitDir(dir, repo string) errors.GitGhostError {
+	err := util.JustRunCmd(
+		exec.Command("git", "init", "-q", dir),
+	)
+	if err != nil {
+		return errors.WithStack(err)
 	}
-	args = append(args, repo, dir)
-	cmd := exec.Command("git", args...)
-	return util.JustRunCmd(cmd)
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "remote", "add", ORIGIN, repo),
+	)
+}
+
+// InitializeBareGitDir initializes an empty bare git directory on dir whose origin is repo.
+func InitializeBareGitDir(dir, repo string) errors.GitGhostError {
+	err := util.JustRunCmd(
+		exec.Command("git", "init", "-q", "--bare", dir),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "remote", "add", ORIGIN, repo),
+	)
+}
+
+// FetchCommit fetches only commit from its origin without its history.
+// All branches are fetched instead if the origin doesn't allow fetching commits by hash.
+func FetchCommit(dir, commit string) errors.GitGhostError {
+	err := util.JustRunCmd(
+		exec.Command("git", "-C", dir, "fetch", "-q", "--depth", "1", "--no-tags", ORIGIN, commit),
+	)
+	if err == nil {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"dir":    dir,
+		"commit": commit,
+		"error":  err.Error(),
+	}).Info("failed to fetch a commit by hash. fetching all branches instead")
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "fetch", "-q", "--no-tags", ORIGIN),
+	)
+}
+
+// FetchBranches fetches only the tips of branches from its origin to their remote tracking branches
+func FetchBranches(dir string, branchNames ...string) errors.GitGhostError {
+	return FetchBranchesFrom(dir, ORIGIN, branchNames...)
+}
+
+// FetchBranchesFrom fetches only the tips of branches from repo to remote tracking branches of its origin
+func FetchBranchesFrom(dir, repo string, branchNames ...string) errors.GitGhostError {
+	if len(branchNames) == 0 {
+		return nil
+	}
+	args := []string{"-C", dir, "fetch", "-q", "--depth", "1", "--no-tags", repo}
+	for _, name := range branchNames {
+		args = append(args, fmt.Sprintf("+refs/heads/%s:refs/remotes/%s/%s", name, ORIGIN, name))
+	}
+	return util.JustRunCmd(
+		exec.Command("git", args...),
+	)
+}
+
+// FetchRefs fetches only the tips of refs from its origin to the same refs on dir
+func FetchRefs(dir string, refs ...string) errors.GitGhostError {
+	if len(refs) == 0 {
+		return nil
+	}
+	args := []string{"-C", dir, "fetch", "-q", "--depth", "1", "--no-tags", ORIGIN}
+	for _, ref := range refs {
+		args = append(args, fmt.Sprintf("+%s:%s", ref, ref))
+	}
+	return util.JustRunCmd(
+		exec.Command("git", args...),
+	)
+}
+
+// MirrorBranches fetches branches from its origin to the same branches on dir
+func MirrorBranches(dir string, branchNames ...string) errors.GitGhostError {
+	if len(branchNames) == 0 {
+		return nil
+	}
+	args := []string{"-C", dir, "fetch", "-q", "--no-tags", ORIGIN}
+	for _, name := range branchNames {
+		args = append(args, fmt.Sprintf("+refs/heads/%s:refs/heads/%s", name, name))
+	}
+	return util.JustRunCmd(
+		exec.Command("git", args...),
+	)
+}
+
+// DeleteBranches deletes local branches on dir
+func DeleteBranches(dir string, branchNames ...string) errors.GitGhostError {
+	if len(branchNames) == 0 {
+		return nil
+	}
+	return util.JustRunCmd(
+		exec.Command("git", append([]string{"-C", dir, "branch", "-q", "-D"}, branchNames...)...),
+	)
 }
 
 // CopyUserConfig copies user config from source directory to destination directory.
@@ -80,32 +174,6 @@ func SetUserConfig(dir, name, email string) errors.GitGhostError {
 	return nil
 }
 
-// CommitAndPush commits and push to its origin
-func CommitAndPush(dir, filename, message, committish string) errors.GitGhostError {
-	err := CommitFile(dir, filename, message)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	err = Push(dir, committish)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	return nil
-}
-
-// CommitFile commits a file
-func CommitFile(dir, filename, message string) errors.GitGhostError {
-	err := util.JustRunCmd(
-		exec.Command("git", "-C", dir, "add", filename),
-	)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	return util.JustRunCmd(
-		exec.Command("git", "-C", dir, "commit", "-q", filename, "-m", message),
-	)
-}
-
 // DeleteRemoteBranches delete branches from its origin
 func DeleteRemoteBranches(dir string, branchNames ...string) errors.GitGhostError {
 	args := []string{"-C", dir, "push", "origin"}
@@ -133,10 +201,63 @@ func Pull(dir, committish string) errors.GitGhostError {
 	)
 }
 
-// CreateOrphanBranch creates an orphan branch on dir
-func CreateOrphanBranch(dir, branch string) errors.GitGhostError {
+// FetchRemoteCommits fetches commits at heads of branches of remote on dir without updating any refs
+func FetchRemoteCommits(dir, remote string, commits ...string) errors.GitGhostError {
+	args := append([]string{"-C", dir, "fetch", "-q", "--no-tags", remote}, commits...)
 	return util.JustRunCmd(
-		exec.Command("git", "-C", dir, "checkout", "--orphan", branch),
+		exec.Command("git", args...),
+	)
+}
+
+// CreateOrphanCommit creates a commit without any parents only by plumbing commands and returns its hash.
+// files maps file names in the commit to paths of their contents.
+func CreateOrphanCommit(dir, message string, files map[string]string) (string, errors.GitGhostError) {
+	return CreateCommit(dir, message, files)
+}
+
+// CreateCommit creates a commit on parents only by plumbing commands and returns its hash.
+// files maps file names in the commit to paths of their contents.
+func CreateCommit(dir, message string, files map[string]string, parents ...string) (string, errors.GitGhostError) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var tree bytes.Buffer
+	for _, name := range names {
+		blob, err := util.JustOutputCmd(
+			exec.Command("git", "-C", dir, "hash-object", "-w", files[name]),
+		)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		tree.WriteString(fmt.Sprintf("100644 blob %s\t%s\n", strings.TrimSpace(string(blob)), name))
+	}
+
+	cmd := exec.Command("git", "-C", dir, "mktree")
+	cmd.Stdin = &tree
+	treeHash, err := util.JustOutputCmd(cmd)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	args := []string{"-C", dir, "commit-tree", strings.TrimSpace(string(treeHash)), "-m", message}
+	for _, parent := range parents {
+		args = append(args, "-p", parent)
+	}
+	commitHash, err := util.JustOutputCmd(
+		exec.Command("git", args...),
+	)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimSpace(string(commitHash)), nil
+}
+
+// UpdateRef points ref to commit on dir
+func UpdateRef(dir, ref, commit string) errors.GitGhostError {
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "update-ref", ref, commit),
 	)
 }
 
@@ -146,3 +267,132 @@ func ResetHardToBranch(dir, branch string) errors.GitGhostError {
 		exec.Command("git", "-C", dir, "reset", "--hard", branch),
 	)
 }
+
+// GetTopLevelDir returns the top directory of the working tree containing dir
+func GetTopLevelDir(dir string) (string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel"),
+	)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimRight(string(output), "\r\n"), nil
+}
+
+// UpdateSubmodule initializes a submodule at path in dir and checks out the commit recorded in dir
+func UpdateSubmodule(dir, path string) errors.GitGhostError {
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "submodule", "update", "-q", "--init", "--", path),
+	)
+}
+
+// CheckoutDetached checks out commit on dir with detached HEAD.
+// The commit is fetched from origin if it does not exist on dir.
+func CheckoutDetached(dir, commit string) errors.GitGhostError {
+	exists, err := CommittishExists(dir, commit)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err = util.JustRunCmd(
+			exec.Command("git", "-C", dir, "fetch", "-q", ORIGIN, commit),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "checkout", "-q", "--detach", commit),
+	)
+}
+
+// AddWorktree adds a worktree of dir on path checking out commit.
+// A new branch is created at commit if branch is not empty, otherwise HEAD of the worktree is detached.
+func AddWorktree(dir, path, commit, branch string) errors.GitGhostError {
+	args := []string{"-C", dir, "worktree", "add", "-q"}
+	if branch != "" {
+		args = append(args, "-b", branch)
+	} else {
+		args = append(args, "--detach")
+	}
+	return util.JustRunCmd(
+		exec.Command("git", append(args, path, commit)...),
+	)
+}
+
+// RemoveWorktree removes the worktree of dir on path even if it has local changes
+func RemoveWorktree(dir, path string) errors.GitGhostError {
+	return util.JustRunCmd(
+		exec.Command("git", "-C", dir, "worktree", "remove", "--force", path),
+	)
+}
+
+// ListRemoteURLs returns URLs of remotes on dir keyed by their names
+func ListRemoteURLs(dir string) (map[string]string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "config", "--get-regexp", `^remote\..*\.url$`),
+	)
+	urls := map[string]string{}
+	if err != nil {
+		if util.GetExitCode(err.Cause()) == 1 {
+			// exit 1 is for no remotes
+			return urls, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		tokens := strings.SplitN(line, " ", 2)
+		if len(tokens) != 2 {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(tokens[0], "remote."), ".url")
+		urls[name] = tokens[1]
+	}
+	return urls, nil
+}
+
+// IsShallowRepository returns true if dir is a shallow clone
+func IsShallowRepository(dir string) (bool, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "rev-parse", "--is-shallow-repository"),
+	)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return strings.TrimSpace(string(output)) == "true", nil
+}
+
+// GetCurrentBranchName returns the name of the branch checked out on dir.  It returns empty string on detached HEAD.
+func GetCurrentBranchName(dir string) (string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "symbolic-ref", "-q", "--short", "HEAD"),
+	)
+	if err != nil {
+		if util.GetExitCode(err.Cause()) == 1 {
+			// exit 1 is for detached HEAD
+			return "", nil
+		}
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ReadFileOnRef returns content of file on ref in dir.  It returns nil if the file does not exist.
+func ReadFileOnRef(dir, ref, file string) ([]byte, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "ls-tree", "--name-only", ref, "--", file),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if strings.TrimSpace(string(output)) == "" {
+		return nil, nil
+	}
+	content, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "cat-file", "blob", fmt.Sprintf("%s:%s", ref, file)),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return content, nil
+}
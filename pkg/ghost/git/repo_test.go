@@ -0,0 +1,69 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	output, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestCreateCommit(t *testing.T) {
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	if err := git.SetUserConfig(dir, "Git Ghost", "git-ghost@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{}
+	for name, content := range map[string]string{"b.patch": "b\n", "a.json": "a\n"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		contents[name] = path
+	}
+
+	orphan, err := git.CreateOrphanCommit(dir, "orphan", contents)
+	assert.Nil(t, err)
+	assert.Equal(t, orphan, runGit(t, dir, "rev-list", "--max-parents=0", orphan))
+	assert.Equal(t, "a.json\nb.patch", runGit(t, dir, "ls-tree", "--name-only", orphan))
+	assert.Equal(t, "b", runGit(t, dir, "cat-file", "blob", orphan+":b.patch"))
+
+	child, err := git.CreateCommit(dir, "child", map[string]string{"a.json": contents["a.json"]}, orphan)
+	assert.Nil(t, err)
+	assert.Equal(t, orphan, runGit(t, dir, "rev-parse", child+"^"))
+	assert.Equal(t, "child", runGit(t, dir, "log", "-1", "--format=%s", child))
+
+	// Nothing is checked out
+	assert.Nil(t, git.UpdateRef(dir, "refs/heads/ghost/test", child))
+	assert.Equal(t, child, runGit(t, dir, "rev-parse", "ghost/test"))
+	assert.Equal(t, "", runGit(t, dir, "status", "--porcelain"))
+	_, statErr := os.Stat(filepath.Join(dir, "a.json"))
+	assert.True(t, os.IsNotExist(statErr))
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package git
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// HasLocalChanges returns true if dir has changes of tracked files in its working tree or index
+func HasLocalChanges(dir string) (bool, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "status", "--porcelain", "--untracked-files=no"),
+	)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(output)) != "", nil
+}
+
+// StashPush stashes changes of tracked files on dir and returns the commit of the stash entry
+func StashPush(dir, message string) (string, errors.GitGhostError) {
+	err := util.JustRunCmd(
+		exec.Command("git", "-C", dir, "stash", "push", "-q", "-m", message),
+	)
+	if err != nil {
+		return "", err
+	}
+	return ResolveCommittish(dir, "stash@{0}")
+}
+
+// StashPop applies the latest stash entry on dir and drops it.
+// Files changed in the working tree are staged beforehand so that the stash entry is merged with them,
+// and the index is rebuilt afterwards from the one before applying it and the changes staged in it,
+// so that staged and unstaged changes are kept separated on both sides.
+// The stash entry is kept if it conflicts, and files with conflicts are returned.
+func StashPop(dir string) ([]string, errors.GitGhostError) {
+	output, err := util.JustOutputCmd(
+		exec.Command("git", "-C", dir, "write-tree"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	index := strings.TrimSpace(string(output))
+	err = util.JustRunCmd(
+		exec.Command("git", "-C", dir, "add", "-u"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = util.JustRunCmd(
+		exec.Command("git", "-C", dir, "stash", "apply", "-q"),
+	)
+	if err != nil {
+		unmerged, ggerr := ListUnmergedFiles(dir)
+		if ggerr != nil || len(unmerged) == 0 {
+			return nil, err
+		}
+		return unmerged, nil
+	}
+	err = util.JustRunCmd(
+		exec.Command("git", "-C", dir, "read-tree", index),
+	)
+	if err != nil {
+		return nil, err
+	}
+	staged, err := util.JustOutputCmd(
+		exec.Command("git", canonicalDiffArgs(dir, "stash@{0}^1", "stash@{0}^2")...),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(staged) > 0 {
+		cmd := exec.Command("git", "-C", dir, "apply", "--cached")
+		cmd.Stdin = bytes.NewReader(staged)
+		err = util.JustRunCmd(cmd)
+		if err != nil {
+			return nil, errors.Errorf("failed to stage changes staged in the stash entry. they are left unstaged: %s", err.Error())
+		}
+	}
+	return []string{}, util.JustRunCmd(
+		exec.Command("git", "-C", dir, "stash", "drop", "-q"),
+	)
+}
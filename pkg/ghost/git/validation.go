@@ -16,6 +16,7 @@ package git
 
 import (
 	"os/exec"
+	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
@@ -30,12 +31,54 @@ func ValidateGit() errors.GitGhostError {
 
 // ValidateCommittish check committish is valid on dir
 func ValidateCommittish(dir, committish string) errors.GitGhostError {
+	exists, err := CommittishExists(dir, committish)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("%s does not exist", committish)
+	}
+	return nil
+}
+
+// CommittishExists checks committish exists on dir or not
+func CommittishExists(dir, committish string) (bool, errors.GitGhostError) {
 	output, err := util.JustOutputCmd(
 		exec.Command("git", "-C", dir, "cat-file", "-e", committish),
 	)
 	if err != nil && util.GetExitCode(err.Cause()) == 1 && len(output) == 0 {
 		// exit 1 is for unexisting committish.
-		return errors.Errorf("%s does not exist", committish)
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// IsCommitReachableFromAny checks commit exists on dir and is reachable from any of heads, which must exist on dir
+func IsCommitReachableFromAny(dir, commit string, heads []string) (bool, errors.GitGhostError) {
+	exists, err := CommittishExists(dir, commit)
+	if err != nil || !exists {
+		return false, err
+	}
+	revs := []string{commit}
+	for _, head := range heads {
+		revs = append(revs, "^"+head)
+	}
+	cmd := exec.Command("git", "-C", dir, "rev-list", "-n", "1", "--stdin")
+	cmd.Stdin = strings.NewReader(strings.Join(revs, "\n") + "\n")
+	output, err := util.JustOutputCmd(cmd)
+	if err != nil {
+		return false, err
+	}
+	return len(output) == 0, nil
+}
+
+// ValidateRefName checks ref is a well-formed ref name
+func ValidateRefName(ref string) errors.GitGhostError {
+	err := util.JustRunCmd(
+		exec.Command("git", "check-ref-format", ref),
+	)
+	if err != nil {
+		return errors.Errorf("%s is not a valid ref name", ref)
 	}
-	return err
+	return nil
 }
@@ -31,12 +31,19 @@ type ListOptions struct {
 	types.WorkingEnvSpec
 	*types.ListCommitsBranchSpec
 	*types.ListDiffBranchSpec
+	// WithManifests fetches listed ghost branches to read their manifests
+	WithManifests bool
+	// Selector filters ghost branches by labels in their manifests.  Ghost branches are fetched to read them.
+	Selector map[string]string
 }
 
 // ListResult contains results of List func
 type ListResult struct {
 	*types.CommitsBranches
 	*types.DiffBranches
+	// Manifests are manifests of listed ghost branches keyed by their branch names.
+	// It is nil unless ListOptions.WithManifests is set, and ghost branches without manifests are not contained.
+	Manifests map[string]*types.Manifest
 }
 
 // List returns ghost branches list per ghost branch type
@@ -63,9 +70,102 @@ func List(options ListOptions) (*ListResult, errors.GitGhostError) {
 		res.DiffBranches = &branches
 	}
 
+	if options.WithManifests || len(options.Selector) > 0 {
+		manifests, err := loadManifests(options.WorkingEnvSpec, res.ghostBranches())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(options.Selector) > 0 {
+			res.selectByLabels(manifests, options.Selector)
+		}
+		if options.WithManifests {
+			res.Manifests = manifests
+		}
+	}
+
 	return &res, nil
 }
 
+func (res *ListResult) selectByLabels(manifests map[string]*types.Manifest, selector map[string]string) {
+	if res.CommitsBranches != nil {
+		branches := types.CommitsBranches{}
+		for _, branch := range *res.CommitsBranches {
+			if manifests[branch.BranchName()].MatchLabels(selector) {
+				branches = append(branches, branch)
+			}
+		}
+		res.CommitsBranches = &branches
+	}
+	if res.DiffBranches != nil {
+		branches := types.DiffBranches{}
+		for _, branch := range *res.DiffBranches {
+			if manifests[branch.BranchName()].MatchLabels(selector) {
+				branches = append(branches, branch)
+			}
+		}
+		res.DiffBranches = &branches
+	}
+}
+
+func (res *ListResult) ghostBranches() []types.GhostBranch {
+	branches := []types.GhostBranch{}
+	if res.CommitsBranches != nil {
+		branches = append(branches, res.CommitsBranches.AsGhostBranches()...)
+	}
+	if res.DiffBranches != nil {
+		branches = append(branches, res.DiffBranches.AsGhostBranches()...)
+	}
+	return branches
+}
+
+func loadManifests(workingEnvSpec types.WorkingEnvSpec, branches []types.GhostBranch) (map[string]*types.Manifest, errors.GitGhostError) {
+	manifests := map[string]*types.Manifest{}
+	if len(branches) == 0 {
+		return manifests, nil
+	}
+	workingEnv, err := workingEnvSpec.Initialize()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredGitGhostError(workingEnv.Clean)
+
+	err = forEachBranchNamesBatch(branches, func(branchNames []string) errors.GitGhostError {
+		return workingEnv.FetchBranches(branchNames...)
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, branch := range branches {
+		manifest, err := types.LoadManifest(*workingEnv, branch)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if manifest != nil {
+			manifests[branch.BranchName()] = manifest
+		}
+	}
+	return manifests, nil
+}
+
+// manifestColumns returns columns of the manifest of branch if manifests are loaded
+func (res *ListResult) manifestColumns(branch types.GhostBranch) []string {
+	if res.Manifests == nil {
+		return []string{}
+	}
+	manifest, ok := res.Manifests[branch.BranchName()]
+	if !ok {
+		return []string{fmt.Sprintf("%-20s", "-"), "-"}
+	}
+	return []string{fmt.Sprintf("%-20s", manifest.CreatedAt.Local().Format("2006-01-02 15:04:05")), manifest.Author}
+}
+
+func (res *ListResult) manifestHeaders() []string {
+	if res.Manifests == nil {
+		return []string{}
+	}
+	return []string{fmt.Sprintf("%-20s", "Created"), "Author"}
+}
+
 // PrettyString pretty prints ListResult
 func (res *ListResult) PrettyString(headers bool, output string) string {
 	// TODO: Make it prettier
@@ -86,6 +186,7 @@ func (res *ListResult) PrettyString(headers bool, output string) string {
 				columns = append(columns, fmt.Sprintf("%-40s", "Remote Base"))
 				columns = append(columns, fmt.Sprintf("%-40s", "Local Base"))
 			}
+			columns = append(columns, res.manifestHeaders()...)
 			buffer.WriteString(fmt.Sprintf("%s\n", strings.Join(columns, " ")))
 		}
 		for _, branch := range branches {
@@ -99,6 +200,7 @@ func (res *ListResult) PrettyString(headers bool, output string) string {
 				columns = append(columns, branch.CommitHashFrom)
 				columns = append(columns, branch.CommitHashTo)
 			}
+			columns = append(columns, res.manifestColumns(branch)...)
 			buffer.WriteString(fmt.Sprintf("%s\n", strings.Join(columns, " ")))
 		}
 		if headers {
@@ -121,6 +223,7 @@ func (res *ListResult) PrettyString(headers bool, output string) string {
 				columns = append(columns, fmt.Sprintf("%-40s", "Local Base"))
 				columns = append(columns, fmt.Sprintf("%-40s", "Local Mod"))
 			}
+			columns = append(columns, res.manifestHeaders()...)
 			buffer.WriteString(fmt.Sprintf("%s\n", strings.Join(columns, " ")))
 		}
 		for _, branch := range branches {
@@ -134,6 +237,7 @@ func (res *ListResult) PrettyString(headers bool, output string) string {
 				columns = append(columns, branch.CommitHashFrom)
 				columns = append(columns, branch.DiffHash)
 			}
+			columns = append(columns, res.manifestColumns(branch)...)
 			buffer.WriteString(fmt.Sprintf("%s\n", strings.Join(columns, " ")))
 		}
 		if headers {
@@ -0,0 +1,44 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ResolveNameOptions represents arg for ResolveName func
+type ResolveNameOptions struct {
+	types.WorkingEnvSpec
+	// Prefix is a prefix of ghost branches
+	Prefix string
+	// Name is a name given by push
+	Name string
+}
+
+// ResolveName returns the alias of ghost branches named options.Name
+func ResolveName(options ResolveNameOptions) (*types.Alias, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("resolve name with")
+
+	we, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredGitGhostError(we.Clean)
+	return types.LoadAlias(*we, options.Prefix, options.Name)
+}
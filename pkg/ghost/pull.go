@@ -15,6 +15,9 @@
 package ghost
 
 import (
+	"os"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
 	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
@@ -27,19 +30,145 @@ type PullOptions struct {
 	types.WorkingEnvSpec
 	*types.CommitsBranchSpec
 	*types.PullableDiffBranchSpec
+	// Worktree is a path where a new worktree of SrcDir is added at the base of ghost branches.
+	// Ghost branches are applied there instead of SrcDir if it is set.
+	Worktree string
+	// WorktreeBranch is a branch created for Worktree.  HEAD of Worktree is detached if it is empty.
+	WorktreeBranch string
+	// AutoStash makes local changes stashed before applying ghost branches and restored after that.
+	// Pull fails on a working directory with local changes unless it is set.
+	AutoStash bool
+	// Check makes ghost branches applied with 3-way merge in a temporary worktree at CheckBase
+	// to report results per file and Distance without modifying SrcDir.
+	Check bool
+	// CheckBase is a committish which ghost branches are checked against with Check.  It defaults to HEAD.
+	CheckBase string
+}
+
+// PullResult contains pulled ghost branches in Pull func
+type PullResult struct {
+	*types.CommitsBranch
+	*types.DiffBranch
+	// Files are results per file of applying ghost branches with 3-way merge.
+	// It is empty unless ThreeWay is set.
+	Files types.FileApplyResults
+	// Worktree is a path of the worktree where ghost branches are applied if it is added
+	Worktree string
+	// Stash is a commit of the stash entry keeping local changes with AutoStash if they are not restored cleanly.
+	// It is empty if they are restored or there are no local changes.
+	Stash string
+	// StashConflicts are files with conflicts between ghost branches and local changes restored with AutoStash
+	StashConflicts []string
+	// DiffSkipped is true if the diff is neither applied nor checked because the commits are applied with conflicts
+	DiffSkipped bool
+	// Distance is a distance between the base checked against and CommitHashFrom of ghost branches.
+	// It is nil unless Check is set, or if CommitHashFrom does not exist in SrcDir.
+	Distance *Distance
+}
+
+// Distance represents numbers of commits reachable only from either of 2 commits
+type Distance struct {
+	// Base is a commit which ghost branches are checked against
+	Base string
+	// From is CommitHashFrom of ghost branches
+	From string
+	// Ahead is a number of commits only in the base checked against
+	Ahead int
+	// Behind is a number of commits only in CommitHashFrom of ghost branches
+	Behind int
 }
 
-func pullAndApply(spec types.PullableGhostBranchSpec, we types.WorkingEnv) errors.GitGhostError {
+func pullAndApply(spec types.PullableGhostBranchSpec, we types.WorkingEnv) (types.GhostBranch, types.FileApplyResults, errors.GitGhostError) {
 	pulledBranch, err := spec.PullBranch(we)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	return pulledBranch.Apply(we)
+	files, err := pulledBranch.Apply(we)
+	return pulledBranch, files, err
 }
 
 // Pull pulls ghost branches and apply to workind directory
-func Pull(options PullOptions) errors.GitGhostError {
+//
+// With ThreeWay, conflicts are left in the working directory and reported in the result instead of failing.
+// The diff is not applied if the commits are applied with conflicts because 'git am' has to be continued first.
+//
+// With AutoStash, local changes are kept in the stash and reported in the result
+// if ghost branches are applied with conflicts or the local changes conflict with them.
+//
+// With Check, SrcDir is not modified even if it has local changes.
+func Pull(options PullOptions) (*PullResult, errors.GitGhostError) {
 	log.WithFields(util.ToFields(options)).Debug("pull command with")
+	if options.Check {
+		return check(options)
+	}
+	result := PullResult{Files: types.FileApplyResults{}, StashConflicts: []string{}}
+	if options.Worktree != "" {
+		err := addWorktree(&options)
+		if err != nil {
+			return nil, err
+		}
+		result.Worktree = options.Worktree
+	}
+
+	dirty, err := git.HasLocalChanges(options.SrcDir)
+	if err != nil {
+		return nil, err
+	}
+	stash := ""
+	if dirty {
+		if !options.AutoStash {
+			return nil, errors.Errorf("%s has local changes. commit or stash them, or pull with autostash", options.SrcDir)
+		}
+		stash, err = git.StashPush(options.SrcDir, "git-ghost autostash")
+		if err != nil {
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"srcDir": options.SrcDir,
+			"stash":  stash,
+		}).Info("stashed local changes")
+	}
+
+	err = pullBranches(options, &result)
+	if stash == "" {
+		return &result, err
+	}
+	if err == nil && result.Files.Conflicted() {
+		log.WithFields(log.Fields{
+			"srcDir": options.SrcDir,
+			"stash":  stash,
+		}).Warn("local changes are kept in the stash because ghost branches are applied with conflicts. run 'git stash pop' after resolving them")
+		result.Stash = stash
+		return &result, nil
+	}
+	conflicts, popErr := git.StashPop(options.SrcDir)
+	if popErr != nil {
+		log.WithFields(log.Fields{
+			"srcDir": options.SrcDir,
+			"stash":  stash,
+		}).Error("failed to restore local changes. they are kept in the stash")
+		if err != nil {
+			return nil, err
+		}
+		return nil, popErr
+	}
+	if len(conflicts) > 0 {
+		log.WithFields(log.Fields{
+			"srcDir":    options.SrcDir,
+			"stash":     stash,
+			"conflicts": conflicts,
+		}).Warn("local changes are restored with conflicts. they are also kept in the stash")
+		result.Stash = stash
+		result.StashConflicts = conflicts
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// pullBranches pulls ghost branches and apply them to SrcDir, and stores them to result
+func pullBranches(options PullOptions, result *PullResult) errors.GitGhostError {
 	we, err := options.WorkingEnvSpec.Initialize()
 	if err != nil {
 		return errors.WithStack(err)
@@ -47,17 +176,140 @@ func Pull(options PullOptions) errors.GitGhostError {
 	defer util.LogDeferredGitGhostError(we.Clean)
 
 	if options.CommitsBranchSpec != nil {
-		err := pullAndApply(*options.CommitsBranchSpec, *we)
+		branch, files, err := pullAndApply(*options.CommitsBranchSpec, *we)
 		if err != nil {
 			return errors.WithStack(err)
 		}
+		result.CommitsBranch = branch.(*types.CommitsBranch)
+		result.Files = append(result.Files, files...)
+		if files.Conflicted() {
+			if options.PullableDiffBranchSpec != nil {
+				result.DiffSkipped = true
+				log.WithFields(util.ToFields(options)).Warn("diff is not applied because commits are applied with conflicts. resolve them, run 'git am --continue' and pull the diff again")
+			}
+			return nil
+		}
 	}
 
 	if options.PullableDiffBranchSpec != nil {
-		err := pullAndApply(*options.PullableDiffBranchSpec, *we)
-		return errors.WithStack(err)
+		branch, files, err := pullAndApply(*options.PullableDiffBranchSpec, *we)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		result.DiffBranch = branch.(*types.DiffBranch)
+		result.Files = append(result.Files, files...)
+		return nil
 	}
 
-	log.WithFields(util.ToFields(options)).Warn("pull command has nothing to do with")
+	if options.CommitsBranchSpec == nil {
+		log.WithFields(util.ToFields(options)).Warn("pull command has nothing to do with")
+	}
+	return nil
+}
+
+// check applies ghost branches in a temporary worktree at CheckBase, and returns results of them with Distance
+func check(options PullOptions) (*PullResult, errors.GitGhostError) {
+	result := PullResult{Files: types.FileApplyResults{}, StashConflicts: []string{}}
+	checkBase := options.CheckBase
+	if checkBase == "" {
+		checkBase = "HEAD"
+	}
+	base, err := git.ResolveCommittish(options.SrcDir, checkBase)
+	if err != nil {
+		return nil, err
+	}
+	_, err = resolveBase(&options)
+	if err != nil {
+		return nil, err
+	}
+
+	srcDir := options.SrcDir
+	dir, oserr := os.MkdirTemp(options.GhostWorkingDir, "git-ghost-check-")
+	if oserr != nil {
+		return nil, errors.WithStack(oserr)
+	}
+	defer util.LogDeferredError(func() error { return os.RemoveAll(dir) })
+	log.WithFields(log.Fields{
+		"srcDir":   srcDir,
+		"worktree": dir,
+		"base":     base,
+	}).Debug("adding temporary worktree to check ghost branches")
+	err = git.AddWorktree(srcDir, dir, base, "")
+	if err != nil {
+		return nil, err
+	}
+	defer util.LogDeferredGitGhostError(func() errors.GitGhostError { return git.RemoveWorktree(srcDir, dir) })
+
+	options.WorkingEnvSpec.SrcDir = dir
+	options.WorkingEnvSpec.ThreeWay = true
+	err = pullBranches(options, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	from := ""
+	if result.CommitsBranch != nil {
+		from = result.CommitsBranch.CommitHashFrom
+	} else if result.DiffBranch != nil {
+		from = result.DiffBranch.CommitHashFrom
+	}
+	if from == "" {
+		return &result, nil
+	}
+	ahead, behind, err := git.CountDivergentCommits(srcDir, base, from)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"srcDir": srcDir,
+			"base":   base,
+			"from":   from,
+		}).Warn("failed to count commits between the base and ghost branches. the base of ghost branches may not exist in the working dir")
+		return &result, nil
+	}
+	result.Distance = &Distance{Base: base, From: from, Ahead: ahead, Behind: behind}
+	return &result, nil
+}
+
+// resolveBase resolves the base of ghost branches to be pulled in SrcDir, and replaces it in copied specs of options.
+// It is needed before pulling them in another worktree because committish like HEAD~1 means another commit there.
+// A full commit hash is returned as it is if it is not in SrcDir.
+func resolveBase(options *PullOptions) (string, errors.GitGhostError) {
+	var base *string
+	if options.CommitsBranchSpec != nil {
+		spec := *options.CommitsBranchSpec
+		options.CommitsBranchSpec = &spec
+		base = &spec.CommittishFrom
+	} else if options.PullableDiffBranchSpec != nil {
+		spec := *options.PullableDiffBranchSpec
+		options.PullableDiffBranchSpec = &spec
+		base = &spec.CommittishFrom
+	} else {
+		return "", errors.New("no ghost branches to be pulled")
+	}
+	commit, err := types.ResolveCommittishOrHash(options.SrcDir, *base)
+	if err != nil {
+		return "", err
+	}
+	*base = commit
+	return commit, nil
+}
+
+// addWorktree adds Worktree at the base of ghost branches to be pulled, and makes options pull them there.
+func addWorktree(options *PullOptions) errors.GitGhostError {
+	commit, err := resolveBase(options)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"srcDir":   options.SrcDir,
+		"worktree": options.Worktree,
+		"base":     commit,
+		"branch":   options.WorktreeBranch,
+	}).Info("adding worktree")
+	err = git.AddWorktree(options.SrcDir, options.Worktree, commit, options.WorktreeBranch)
+	if err != nil {
+		return err
+	}
+	options.WorkingEnvSpec.SrcDir = options.Worktree
 	return nil
 }
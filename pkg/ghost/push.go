@@ -15,6 +15,11 @@
 package ghost
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
 	"github.com/pfnet-research/git-ghost/pkg/util"
@@ -23,81 +28,281 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// UntrackedFilesMode specifies which untracked files are included in a diff ghost branch
+type UntrackedFilesMode string
+
+const (
+	// UntrackedFilesNo includes no untracked files
+	UntrackedFilesNo UntrackedFilesMode = "no"
+	// UntrackedFilesNormal includes untracked files not ignored by .gitignore
+	UntrackedFilesNormal UntrackedFilesMode = "normal"
+	// UntrackedFilesAll includes all untracked files
+	UntrackedFilesAll UntrackedFilesMode = "all"
+)
+
 // PushOptions represents arg for Push func
 type PushOptions struct {
 	types.WorkingEnvSpec
 	*types.CommitsBranchSpec
 	*types.DiffBranchSpec
+	*types.StashBranchSpec
+	// UntrackedFiles specifies untracked files to be included in the diff ghost branch
+	UntrackedFiles UntrackedFilesMode
+	// MaxUntrackedFileSize is the max size of an untracked file to be included.  0 means unlimited.
+	MaxUntrackedFileSize int64
+	// Labels are key-value pairs recorded in manifests of created ghost branches
+	Labels map[string]string
+	// Message is a note recorded in manifests of created ghost branches and used as their commit messages
+	Message string
+	// Name is a human-friendly name pointed to resultant ghost branches if set
+	Name string
 }
 
 // PushResult contains resultant ghost branches of Push func
 type PushResult struct {
 	*types.CommitsBranch
 	*types.DiffBranch
+	// UntrackedFiles are untracked files included in DiffBranch
+	UntrackedFiles []string
+	// SkippedUntrackedFiles are untracked files skipped because they are larger than MaxUntrackedFileSize
+	SkippedUntrackedFiles []string
+	// UnannotatedBranches are names of ghost branches which Labels and Message are not recorded on
+	// because they already exist in the remote ghost repository
+	UnannotatedBranches []string
 }
 
 // Push pushes create ghost branches and push them to remote ghost repository
+//
+// All ghost branches are created in one working env and pushed at once.
+// Branches which already exist in the remote ghost repository are not committed nor pushed,
+// so they keep labels and messages given when they were pushed first.  They are reported in the result.
+// The contents of the commits branch are not even created if it exists
+// because its name is known before creating them unlike diff branches.
 func Push(options PushOptions) (*PushResult, errors.GitGhostError) {
 	log.WithFields(util.ToFields(options)).Debug("push command with")
 
 	var result PushResult
+	branchSpecs := []types.GhostBranchSpec{}
 	if options.CommitsBranchSpec != nil {
-		branch, err := pushGhostBranch(options.CommitsBranchSpec, options.WorkingEnvSpec)
+		commitsBranch, err := options.CommitsBranchSpec.Branch(options.SrcDir)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
-		commitsBranch, _ := branch.(*types.CommitsBranch)
 		result.CommitsBranch = commitsBranch
 	}
-
 	if options.DiffBranchSpec != nil {
-		branch, err := pushGhostBranch(options.DiffBranchSpec, options.WorkingEnvSpec)
+		diffBranchSpec := *options.DiffBranchSpec
+		if options.UntrackedFiles != "" && options.UntrackedFiles != UntrackedFilesNo {
+			excludedPathspecs, err := types.ReadGhostIgnore(options.SrcDir)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			excludedPathspecs = append(excludedPathspecs, diffBranchSpec.ExcludedPathspecs...)
+			untracked, skipped, err := listUntrackedFiles(options.SrcDir, options.UntrackedFiles == UntrackedFilesAll, options.MaxUntrackedFileSize, diffBranchSpec.Pathspecs, excludedPathspecs)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			result.UntrackedFiles = untracked
+			result.SkippedUntrackedFiles = skipped
+			includedFilepaths := append([]string{}, diffBranchSpec.IncludedFilepaths...)
+			for _, f := range untracked {
+				includedFilepaths = append(includedFilepaths, filepath.Join(options.SrcDir, filepath.FromSlash(f)))
+			}
+			diffBranchSpec.IncludedFilepaths = includedFilepaths
+		}
+		branchSpecs = append(branchSpecs, &diffBranchSpec)
+	}
+	if options.StashBranchSpec != nil {
+		branchSpecs = append(branchSpecs, options.StashBranchSpec)
+	}
+	if len(branchSpecs) == 0 && result.CommitsBranch == nil {
+		return &result, nil
+	}
+
+	workingEnv, err := options.WorkingEnvSpec.Initialize()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer util.LogDeferredGitGhostError(workingEnv.Clean)
+
+	branches := []types.GhostBranch{}
+	for _, branchSpec := range branchSpecs {
+		branch, err := branchSpec.CreateBranch(*workingEnv)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
-		diffBranch, _ := branch.(*types.DiffBranch)
-		result.DiffBranch = diffBranch
+		if branch == nil {
+			continue
+		}
+		switch b := branch.(type) {
+		case *types.DiffBranch:
+			result.DiffBranch = b
+		}
+		branches = append(branches, branch)
+		submoduleBranches, err := types.CreatedSubmoduleBranches(*workingEnv, branch)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		branches = append(branches, submoduleBranches...)
+	}
+
+	if result.CommitsBranch != nil {
+		branches = append([]types.GhostBranch{result.CommitsBranch}, branches...)
+	}
+	branches, existence, err := listGhostBranchExistence(*workingEnv, branches)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if result.CommitsBranch != nil && !existence[result.CommitsBranch.BranchName()] {
+		_, err := options.CommitsBranchSpec.CreateBranch(*workingEnv)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	newBranches := []types.GhostBranch{}
+	for _, branch := range branches {
+		if existence[branch.BranchName()] {
+			log.WithFields(log.Fields{
+				"branch":    branch.BranchName(),
+				"ghostRepo": workingEnv.GhostRepo,
+			}).Info("skipped pushing existing branch")
+			if len(options.Labels) > 0 || options.Message != "" {
+				log.WithFields(log.Fields{
+					"branch":  branch.BranchName(),
+					"labels":  options.Labels,
+					"message": options.Message,
+				}).Warn("labels and message are not recorded because the branch already exists. they are kept as given when it was pushed first")
+				result.UnannotatedBranches = append(result.UnannotatedBranches, branch.BranchName())
+			}
+			continue
+		}
+		newBranches = append(newBranches, branch)
+	}
+
+	if len(options.Labels) > 0 || options.Message != "" {
+		for _, branch := range newBranches {
+			err := types.AnnotateManifest(branch, *workingEnv, options.Labels, options.Message)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+
+	err = pushGhostBranches(*workingEnv, newBranches)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
+	if options.Name != "" {
+		err = nameResult(*workingEnv, options, &result)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
 	return &result, nil
 }
 
-func pushGhostBranch(branchSpec types.GhostBranchSpec, workingEnvSpec types.WorkingEnvSpec) (types.GhostBranch, errors.GitGhostError) {
-	workingEnv, err := workingEnvSpec.Initialize()
+// nameResult points the alias named options.Name to resultant ghost branches
+func nameResult(we types.WorkingEnv, options PushOptions, result *PushResult) errors.GitGhostError {
+	branches := []types.GhostBranch{}
+	prefix := ""
+	if result.CommitsBranch != nil {
+		branches = append(branches, result.CommitsBranch)
+		prefix = result.CommitsBranch.Prefix
+	}
+	if result.DiffBranch != nil {
+		branches = append(branches, result.DiffBranch)
+		prefix = result.DiffBranch.Prefix
+	}
+	if len(branches) == 0 {
+		log.WithFields(log.Fields{
+			"name": options.Name,
+		}).Warn("no ghost branch to be named")
+		return nil
+	}
+	alias, err := types.NewAlias(prefix, options.Name, branches...)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
-	defer util.LogDeferredGitGhostError(workingEnv.Clean)
-	dstDir := workingEnv.GhostDir
-	branch, err := branchSpec.CreateBranch(*workingEnv)
+	log.WithFields(util.ToFields(*alias)).Info("naming ghost branches")
+	return alias.Update(we)
+}
+
+// listUntrackedFiles returns untracked files in srcDir matching pathspecs and not matching excludedPathspecs,
+// and ones skipped because they are larger than maxSize
+func listUntrackedFiles(srcDir string, includeIgnored bool, maxSize int64, pathspecs, excludedPathspecs []string) ([]string, []string, errors.GitGhostError) {
+	files, err := git.ListUntrackedFiles(srcDir, includeIgnored, pathspecs...)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
 	}
-	if branch == nil {
-		return nil, nil
+	files, err = git.FilterFiles(srcDir, files, nil, excludedPathspecs)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
 	}
-	existence, err := git.ValidateRemoteBranchExistence(
-		workingEnv.GhostRepo,
-		branch.BranchName(),
-	)
+	untracked := []string{}
+	skipped := []string{}
+	for _, f := range files {
+		// Skip nested git repositories, which are listed as directories
+		if strings.HasSuffix(f, "/") {
+			continue
+		}
+		if maxSize > 0 {
+			fi, err := os.Lstat(filepath.Join(srcDir, filepath.FromSlash(f)))
+			if err != nil {
+				return nil, nil, errors.WithStack(err)
+			}
+			if fi.Size() > maxSize {
+				skipped = append(skipped, f)
+				continue
+			}
+		}
+		untracked = append(untracked, f)
+	}
+	return untracked, skipped, nil
+}
+
+// listGhostBranchExistence returns unique branches, and whether each of them exists in the remote ghost repository by one ls-remote
+func listGhostBranchExistence(workingEnv types.WorkingEnv, branches []types.GhostBranch) ([]types.GhostBranch, map[string]bool, errors.GitGhostError) {
+	// Ghost branches of submodules might be shared
+	uniqueBranches := make([]types.GhostBranch, 0, len(branches))
+	branchNames := make([]string, 0, len(branches))
+	seen := map[string]bool{}
+	for _, branch := range branches {
+		if seen[branch.BranchName()] {
+			continue
+		}
+		seen[branch.BranchName()] = true
+		uniqueBranches = append(uniqueBranches, branch)
+		branchNames = append(branchNames, branch.BranchName())
+	}
+	existingBranchNames, err := git.ListRemoteBranchNames(workingEnv.GhostRepo, branchNames)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
 	}
-	if existence {
+	existence := map[string]bool{}
+	for _, name := range existingBranchNames {
+		existence[name] = true
+	}
+	return uniqueBranches, existence, nil
+}
+
+// pushGhostBranches commits branches and pushes them at once
+func pushGhostBranches(workingEnv types.WorkingEnv, branches []types.GhostBranch) errors.GitGhostError {
+	refspecs := []string{}
+	for _, branch := range branches {
+		err := types.CommitBranch(branch, workingEnv)
+		if err != nil {
+			return errors.WithStack(err)
+		}
 		log.WithFields(log.Fields{
 			"branch":    branch.BranchName(),
 			"ghostRepo": workingEnv.GhostRepo,
-		}).Info("skipped pushing existing branch")
-		return branch, nil
+		}).Info("pushing branch")
+		refspecs = append(refspecs, fmt.Sprintf("refs/heads/%s:refs/heads/%s", branch.BranchName(), branch.BranchName()))
 	}
-
-	log.WithFields(log.Fields{
-		"branch":    branch.BranchName(),
-		"ghostRepo": workingEnv.GhostRepo,
-	}).Info("pushing branch")
-	err = git.Push(dstDir, branch.BranchName())
-	if err != nil {
-		return nil, errors.WithStack(err)
+	if len(refspecs) == 0 {
+		return nil
 	}
-	return branch, nil
+	return git.Push(workingEnv.GhostDir, refspecs...)
 }
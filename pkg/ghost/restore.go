@@ -0,0 +1,116 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ghost
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
+	"github.com/pfnet-research/git-ghost/pkg/util"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RestoreOptions represents arg for Restore func
+type RestoreOptions struct {
+	types.WorkingEnvSpec
+	// SourceRepo is a URL of the source repository checked out into SrcDir
+	SourceRepo string
+	// Base is a commit of the source repository checked out before pulling ghost branches.
+	// Only the commit is fetched from SourceRepo if it allows.
+	Base string
+	*types.CommitsBranchSpec
+	*types.PullableDiffBranchSpec
+}
+
+// Restore checks out the base commit of the source repository into SrcDir and pulls ghost branches to it
+func Restore(options RestoreOptions) (*PullResult, errors.GitGhostError) {
+	log.WithFields(util.ToFields(options)).Debug("restore command with")
+
+	if options.SourceRepo == "" {
+		return nil, errors.New("source repository must be specified")
+	}
+	entries, statErr := os.ReadDir(options.SrcDir)
+	if statErr == nil && len(entries) > 0 {
+		return nil, errors.Errorf("destination %s already exists and is not an empty directory", options.SrcDir)
+	} else if statErr != nil && !os.IsNotExist(statErr) {
+		return nil, errors.WithStack(statErr)
+	}
+	result, err := restore(options)
+	if err != nil {
+		// Leave the destination as it was so that restore can be retried
+		cleanErr := cleanDestination(options.SrcDir, os.IsNotExist(statErr))
+		if cleanErr != nil {
+			log.WithFields(log.Fields{
+				"srcDir": options.SrcDir,
+				"error":  cleanErr.Error(),
+			}).Warn("failed to clean destination")
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// restore initializes SrcDir as a clone of the base commit and pulls ghost branches to it
+func restore(options RestoreOptions) (*PullResult, errors.GitGhostError) {
+	err := git.InitializeGitDir(options.SrcDir, options.SourceRepo)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = git.FetchCommit(options.SrcDir, options.Base)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = git.CheckoutDetached(options.SrcDir, options.Base)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// Applying commits requires a committer identity, which fresh environments like containers often lack
+	if options.CommitsBranchSpec != nil {
+		if _, _, err := git.GetUserConfig(options.SrcDir); err != nil {
+			name, email := options.WorkingEnvSpec.GhostUser()
+			err = git.SetUserConfig(options.SrcDir, name, email)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+	return Pull(PullOptions{
+		WorkingEnvSpec:         options.WorkingEnvSpec,
+		CommitsBranchSpec:      options.CommitsBranchSpec,
+		PullableDiffBranchSpec: options.PullableDiffBranchSpec,
+	})
+}
+
+// cleanDestination removes dir if created, or files in dir otherwise
+func cleanDestination(dir string, created bool) error {
+	if created {
+		return os.RemoveAll(dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err := os.RemoveAll(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
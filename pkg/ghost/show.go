@@ -15,6 +15,8 @@
 package ghost
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost/types"
@@ -29,6 +31,8 @@ type ShowOptions struct {
 	types.WorkingEnvSpec
 	*types.CommitsBranchSpec
 	*types.PullableDiffBranchSpec
+	// Manifest shows manifests of ghost branches in JSON instead of their contents
+	Manifest bool
 	// if you want to consume and transform the output of `ghost.Show()`,
 	// Please use `io.Pipe()` as below,
 	// ```
@@ -39,14 +43,33 @@ type ShowOptions struct {
 	Writer io.Writer
 }
 
-func pullAndshow(branchSpec types.PullableGhostBranchSpec, we types.WorkingEnv, writer io.Writer) errors.GitGhostError {
+func pullAndshow(branchSpec types.PullableGhostBranchSpec, we types.WorkingEnv, writer io.Writer, manifest bool) errors.GitGhostError {
 	branch, err := branchSpec.PullBranch(we)
 	if err != nil {
 		return err
 	}
+	if manifest {
+		return showManifest(branch, we, writer)
+	}
 	return branch.Show(we, writer)
 }
 
+func showManifest(branch types.GhostBranch, we types.WorkingEnv, writer io.Writer) errors.GitGhostError {
+	manifest, ggerr := types.LoadManifest(we, branch)
+	if ggerr != nil {
+		return ggerr
+	}
+	if manifest == nil {
+		return errors.Errorf("%s has no manifest", branch.BranchName())
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = fmt.Fprintf(writer, "%s\n", content)
+	return errors.WithStack(err)
+}
+
 // Show writes ghost branches contents to option.Writer
 func Show(options ShowOptions) errors.GitGhostError {
 	log.WithFields(util.ToFields(options)).Debug("pull command with")
@@ -57,7 +80,7 @@ func Show(options ShowOptions) errors.GitGhostError {
 			return err
 		}
 		defer util.LogDeferredGitGhostError(we.Clean)
-		err = pullAndshow(options.CommitsBranchSpec, *we, options.Writer)
+		err = pullAndshow(options.CommitsBranchSpec, *we, options.Writer, options.Manifest)
 		if err != nil {
 			return err
 		}
@@ -69,7 +92,7 @@ func Show(options ShowOptions) errors.GitGhostError {
 			return err
 		}
 		defer util.LogDeferredGitGhostError(we.Clean)
-		return pullAndshow(options.PullableDiffBranchSpec, *we, options.Writer)
+		return pullAndshow(options.PullableDiffBranchSpec, *we, options.Writer, options.Manifest)
 	}
 
 	log.WithFields(util.ToFields(options)).Warn("show command has nothing to do with")
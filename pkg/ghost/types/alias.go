@@ -0,0 +1,226 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// AliasFileName is a file name in an alias commit containing its Alias
+const AliasFileName = "alias.json"
+
+// aliasRefPrefix is a prefix of refs of aliases in ghost repositories.
+// They are not under refs/heads/ so that they are never regarded as ghost branches.
+const aliasRefPrefix = "refs/ghost-names"
+
+// Alias is a human-friendly name of ghost branches
+//
+// An alias is stored as a ref in the ghost repository.
+// Each commit of the ref has the previous commit as its parent,
+// so the history of ghost branches which the name pointed to is kept.
+type Alias struct {
+	// Prefix is a prefix of the ghost branches
+	Prefix string `json:"prefix"`
+	// Name is a name of the alias
+	Name string `json:"name"`
+	// CommitHashFrom and CommitHashTo identifies the commits branch if any
+	CommitHashFrom string `json:"commitHashFrom,omitempty"`
+	CommitHashTo   string `json:"commitHashTo,omitempty"`
+	// DiffHashFrom and DiffHash identifies the diff branch if any
+	DiffHashFrom string `json:"diffHashFrom,omitempty"`
+	DiffHash     string `json:"diffHash,omitempty"`
+}
+
+// NewAlias returns an Alias named name pointing to branches
+func NewAlias(prefix, name string, branches ...GhostBranch) (*Alias, errors.GitGhostError) {
+	alias := Alias{
+		Prefix: prefix,
+		Name:   name,
+	}
+	ggerr := git.ValidateRefName(alias.RefName())
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	for _, branch := range branches {
+		switch b := branch.(type) {
+		case *CommitsBranch:
+			alias.CommitHashFrom = b.CommitHashFrom
+			alias.CommitHashTo = b.CommitHashTo
+		case *DiffBranch:
+			alias.DiffHashFrom = b.CommitHashFrom
+			alias.DiffHash = b.DiffHash
+		}
+	}
+	return &alias, nil
+}
+
+// RefName returns the ref of the alias in the ghost repository
+func (alias Alias) RefName() string {
+	return fmt.Sprintf("%s/%s/%s", aliasRefPrefix, alias.Prefix, alias.Name)
+}
+
+// CommitsBranch returns the commits branch which the alias points to.  It returns nil if no commits branch.
+func (alias Alias) CommitsBranch() *CommitsBranch {
+	if alias.CommitHashTo == "" {
+		return nil
+	}
+	return &CommitsBranch{
+		Prefix:         alias.Prefix,
+		CommitHashFrom: alias.CommitHashFrom,
+		CommitHashTo:   alias.CommitHashTo,
+	}
+}
+
+// DiffBranch returns the diff branch which the alias points to.  It returns nil if no diff branch.
+func (alias Alias) DiffBranch() *DiffBranch {
+	if alias.DiffHash == "" {
+		return nil
+	}
+	return &DiffBranch{
+		Prefix:         alias.Prefix,
+		CommitHashFrom: alias.DiffHashFrom,
+		DiffHash:       alias.DiffHash,
+	}
+}
+
+// BranchNames returns names of ghost branches which the alias points to
+func (alias Alias) BranchNames() []string {
+	names := []string{}
+	if b := alias.CommitsBranch(); b != nil {
+		names = append(names, b.BranchName())
+	}
+	if b := alias.DiffBranch(); b != nil {
+		names = append(names, b.BranchName())
+	}
+	return names
+}
+
+// Only returns a copy of the alias pointing only to ghost branches named in branchNames
+func (alias Alias) Only(branchNames ...string) Alias {
+	names := map[string]bool{}
+	for _, name := range branchNames {
+		names[name] = true
+	}
+	if b := alias.CommitsBranch(); b != nil && !names[b.BranchName()] {
+		alias.CommitHashFrom = ""
+		alias.CommitHashTo = ""
+	}
+	if b := alias.DiffBranch(); b != nil && !names[b.BranchName()] {
+		alias.DiffHashFrom = ""
+		alias.DiffHash = ""
+	}
+	return alias
+}
+
+// Update points the alias in the ghost repository to its ghost branches on top of the previous ones
+func (alias Alias) Update(we WorkingEnv) errors.GitGhostError {
+	ref := alias.RefName()
+	exists, ggerr := git.ValidateRemoteRefExistence(we.GhostRepo, ref)
+	if ggerr != nil {
+		return ggerr
+	}
+	parents := []string{}
+	if exists {
+		ggerr = git.FetchRefs(we.GhostDir, ref)
+		if ggerr != nil {
+			return ggerr
+		}
+		parent, ggerr := git.ResolveCommittish(we.GhostDir, ref)
+		if ggerr != nil {
+			return ggerr
+		}
+		parents = append(parents, parent)
+	}
+
+	content, err := json.MarshalIndent(alias, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	dir, err := os.MkdirTemp(we.GhostDir, "git-ghost-alias")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, AliasFileName)
+	err = os.WriteFile(file, append(content, '\n'), 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	message := fmt.Sprintf("Name %s as %s", strings.Join(alias.BranchNames(), " and "), alias.Name)
+	commit, ggerr := git.CreateCommit(we.GhostDir, message, map[string]string{AliasFileName: file}, parents...)
+	if ggerr != nil {
+		return ggerr
+	}
+	return git.Push(we.GhostDir, fmt.Sprintf("%s:%s", commit, ref))
+}
+
+// Delete deletes the alias from the ghost repository
+func (alias Alias) Delete(we WorkingEnv) errors.GitGhostError {
+	return git.DeleteRemoteBranches(we.GhostDir, alias.RefName())
+}
+
+// ListAliases reads all aliases of ghost branches with prefix from the ghost repository
+func ListAliases(we WorkingEnv, prefix string) ([]Alias, errors.GitGhostError) {
+	refPrefix := Alias{Prefix: prefix}.RefName()
+	refNames, ggerr := git.ListRemoteRefNames(we.GhostRepo, refPrefix)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	aliases := make([]Alias, 0, len(refNames))
+	for _, refName := range refNames {
+		alias, ggerr := LoadAlias(we, prefix, strings.TrimPrefix(refName, refPrefix))
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		aliases = append(aliases, *alias)
+	}
+	return aliases, nil
+}
+
+// LoadAlias reads the Alias named name from the ghost repository
+func LoadAlias(we WorkingEnv, prefix, name string) (*Alias, errors.GitGhostError) {
+	ref := Alias{Prefix: prefix, Name: name}.RefName()
+	exists, ggerr := git.ValidateRemoteRefExistence(we.GhostRepo, ref)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	if !exists {
+		return nil, errors.Errorf("ghost named %s does not exist", name)
+	}
+	ggerr = git.FetchRefs(we.GhostDir, ref)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	content, ggerr := git.ReadFileOnRef(we.GhostDir, ref, AliasFileName)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	if content == nil {
+		return nil, errors.Errorf("%s has no %s", ref, AliasFileName)
+	}
+	var alias Alias
+	err := json.Unmarshal(content, &alias)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &alias, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"os"
+	"path"
+	"reflect"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// FileApplyStatus is a status of a file after a ghost branch is applied with 3-way merge
+type FileApplyStatus string
+
+const (
+	// FileApplyClean means the file is patched as is
+	FileApplyClean FileApplyStatus = "clean"
+	// FileApplyMerged means the file is patched by 3-way merge without conflicts
+	FileApplyMerged FileApplyStatus = "merged"
+	// FileApplyConflicted means the file is left with conflict markers
+	FileApplyConflicted FileApplyStatus = "conflicted"
+	// FileApplyPending means the file is changed by commits which are not applied yet because of conflicts
+	FileApplyPending FileApplyStatus = "pending"
+)
+
+// FileApplyResult is a result of applying a ghost branch to a file
+type FileApplyResult struct {
+	// Path is a path of the file relative to the source directory
+	Path   string
+	Status FileApplyStatus
+}
+
+// FileApplyResults is an alias for []FileApplyResult
+type FileApplyResults []FileApplyResult
+
+// Conflicted returns true if any file is left with conflict markers
+func (results FileApplyResults) Conflicted() bool {
+	for _, r := range results {
+		if r.Status == FileApplyConflicted {
+			return true
+		}
+	}
+	return false
+}
+
+// inDirectory returns results whose paths are prefixed with dir
+func (results FileApplyResults) inDirectory(dir string) FileApplyResults {
+	prefixed := FileApplyResults{}
+	for _, r := range results {
+		prefixed = append(prefixed, FileApplyResult{Path: path.Join(dir, r.Path), Status: r.Status})
+	}
+	return prefixed
+}
+
+// applyThreeWay applies ghost on WorkingEnv with 3-way merge and returns results per file
+func applyThreeWay(ghost GhostBranch, we WorkingEnv) (FileApplyResults, errors.GitGhostError) {
+	patchFile := path.Join(we.GhostDir, ghost.FileName())
+	fi, err := os.Stat(patchFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if fi.Size() == 0 {
+		return FileApplyResults{}, nil
+	}
+	files, ggerr := git.ListPatchFiles(we.SrcDir, patchFile)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+
+	merged := map[string]bool{}
+	pending := map[string]bool{}
+	switch ghost.(type) {
+	case CommitsBranch:
+		mergedFiles, ggerr := git.ApplyDiffBundleFileThreeWay(we.SrcDir, patchFile)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		for _, f := range mergedFiles {
+			merged[f] = true
+		}
+		// Commits after the conflicted one are left in 'git am' session
+		pendingFiles, ggerr := git.ListUnappliedBundleFiles(we.SrcDir)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		for _, f := range pendingFiles {
+			pending[f] = true
+		}
+	case DiffBranch:
+		// Files which can't be patched as is are merged
+		for _, f := range files {
+			if git.CheckDiffPatchFile(we.SrcDir, patchFile, f) != nil {
+				merged[f] = true
+			}
+		}
+		ggerr := git.ApplyDiffPatchFileThreeWay(we.SrcDir, patchFile)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+	default:
+		return nil, errors.Errorf("not supported on type = %+v", reflect.TypeOf(ghost))
+	}
+
+	unmergedFiles, ggerr := git.ListUnmergedFiles(we.SrcDir)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	unmerged := map[string]bool{}
+	for _, f := range unmergedFiles {
+		unmerged[f] = true
+	}
+	results := FileApplyResults{}
+	for _, f := range files {
+		status := FileApplyClean
+		if unmerged[f] {
+			status = FileApplyConflicted
+		} else if pending[f] {
+			status = FileApplyPending
+		} else if merged[f] {
+			status = FileApplyMerged
+		}
+		results = append(results, FileApplyResult{Path: f, Status: status})
+	}
+	return results, nil
+}
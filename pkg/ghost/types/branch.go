@@ -17,11 +17,14 @@ package types
 import (
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"sort"
+	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
 	"github.com/pfnet-research/git-ghost/pkg/util"
@@ -40,8 +43,9 @@ type GhostBranch interface {
 	FileName() string
 	// Show writes contents of this ghost branch on passed working env to writer
 	Show(we WorkingEnv, writer io.Writer) errors.GitGhostError
-	// Apply applies contents(diff or patch) of this ghost branch on passed working env
-	Apply(we WorkingEnv) errors.GitGhostError
+	// Apply applies contents(diff or patch) of this ghost branch on passed working env.
+	// Results per file are returned if it is applied with 3-way merge.
+	Apply(we WorkingEnv) (FileApplyResults, errors.GitGhostError)
 }
 
 // interface assetions
@@ -80,6 +84,12 @@ type DiffBranches []DiffBranch
 var commitsBranchNamePattern = regexp.MustCompile(`^([a-z0-9]+)/([a-f0-9]+)-([a-f0-9]+)$`)
 var diffBranchNamePattern = regexp.MustCompile(`^([a-z0-9]+)/([a-f0-9]+)/([a-f0-9]+)$`)
 
+// PathspecFileName is a file name in a DiffBranch recording pathspecs which its diff is limited to
+const PathspecFileName = "pathspec"
+
+// IndexPatchFileName is a file name in a DiffBranch containing staged changes
+const IndexPatchFileName = "index.patch"
+
 // BranchName returns its full branch name on git repository
 func (b CommitsBranch) BranchName() string {
 	return fmt.Sprintf("%s/%s-%s", b.Prefix, b.CommitHashFrom, b.CommitHashTo)
@@ -155,13 +165,44 @@ func (branches DiffBranches) AsGhostBranches() []GhostBranch {
 	return ghostBranches
 }
 
+// CommitBranch creates an orphan commit from contents created by GhostBranchSpec.CreateBranch
+// without any checkout, and points the local branch named after ghost to it.
+// The message in the manifest is used as the commit message if any.
+func CommitBranch(ghost GhostBranch, we WorkingEnv) errors.GitGhostError {
+	contentDir := we.contentDir(ghost)
+	entries, err := os.ReadDir(contentDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	files := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		files[entry.Name()] = filepath.Join(contentDir, entry.Name())
+	}
+	message := "Create ghost commit"
+	manifest, ggerr := readManifest(contentDir)
+	if ggerr != nil {
+		return ggerr
+	}
+	if manifest != nil && manifest.Message != "" {
+		message = manifest.Message
+	}
+	commit, ggerr := git.CreateOrphanCommit(we.GhostDir, message, files)
+	if ggerr != nil {
+		return ggerr
+	}
+	return git.UpdateRef(we.GhostDir, "refs/heads/"+ghost.BranchName(), commit)
+}
+
 func show(ghost GhostBranch, we WorkingEnv, writer io.Writer) errors.GitGhostError {
 	cmd := exec.Command("git", "-C", we.GhostDir, "--no-pager", "cat-file", "-p", fmt.Sprintf("HEAD:%s", ghost.FileName()))
 	cmd.Stdout = writer
 	return util.JustRunCmd(cmd)
 }
 
-func apply(ghost GhostBranch, we WorkingEnv, expectedSrcHead string) errors.GitGhostError {
+func apply(ghost GhostBranch, we WorkingEnv, expectedSrcHead string) (FileApplyResults, errors.GitGhostError) {
 	log.WithFields(util.MergeFields(
 		util.ToFields(ghost),
 		log.Fields{
@@ -173,10 +214,10 @@ func apply(ghost GhostBranch, we WorkingEnv, expectedSrcHead string) errors.GitG
 
 	srcHead, err := git.ResolveCommittish(we.SrcDir, "HEAD")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if srcHead != expectedSrcHead {
+	if srcHead != expectedSrcHead && !we.ThreeWay {
 		message := "HEAD is not equal to expected"
 		log.WithFields(util.MergeFields(
 			util.ToFields(ghost),
@@ -189,14 +230,18 @@ func apply(ghost GhostBranch, we WorkingEnv, expectedSrcHead string) errors.GitG
 		).Warnf("%s. Applying ghost branch might be failed.", message)
 	}
 
+	if we.ThreeWay {
+		return applyThreeWay(ghost, we)
+	}
+
 	// TODO make this instance methods.
 	switch ghost.(type) {
 	case CommitsBranch:
-		return git.ApplyDiffBundleFile(we.SrcDir, path.Join(we.GhostDir, ghost.FileName()))
+		return nil, git.ApplyDiffBundleFile(we.SrcDir, path.Join(we.GhostDir, ghost.FileName()))
 	case DiffBranch:
-		return git.ApplyDiffPatchFile(we.SrcDir, path.Join(we.GhostDir, ghost.FileName()))
+		return nil, git.ApplyDiffPatchFile(we.SrcDir, path.Join(we.GhostDir, ghost.FileName()))
 	default:
-		return errors.Errorf("not supported on type = %+v", reflect.TypeOf(ghost))
+		return nil, errors.Errorf("not supported on type = %+v", reflect.TypeOf(ghost))
 	}
 }
 
@@ -206,31 +251,115 @@ func (bs CommitsBranch) Show(we WorkingEnv, writer io.Writer) errors.GitGhostErr
 }
 
 // Apply applies contents(diff or patch) of this ghost branch on passed working env
-func (bs CommitsBranch) Apply(we WorkingEnv) errors.GitGhostError {
+func (bs CommitsBranch) Apply(we WorkingEnv) (FileApplyResults, errors.GitGhostError) {
 	if bs.CommitHashFrom == bs.CommitHashTo {
 		log.WithFields(log.Fields{
 			"from": bs.CommitHashFrom,
 			"to":   bs.CommitHashTo,
 		}).Warn("skipping apply ghost commits branch because from-hash and to-hash is the same.")
-		return nil
-	}
-	err := apply(bs, we, bs.CommitHashFrom)
-	if err != nil {
-		return err
+		return nil, nil
 	}
-	return nil
+	return apply(bs, we, bs.CommitHashFrom)
 }
 
 // Show writes contents of this ghost branch on passed working env to writer
+//
+// If the diff is limited to some pathspecs, they are written before the diff.
 func (bs DiffBranch) Show(we WorkingEnv, writer io.Writer) errors.GitGhostError {
+	pathspecs, err := bs.Pathspecs(we)
+	if err != nil {
+		return err
+	}
+	if len(pathspecs) > 0 {
+		_, err := fmt.Fprintf(writer, "# pathspec: %s\n", strings.Join(pathspecs, " "))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	submodules, err := readSubmoduleGhosts(we.GhostDir)
+	if err != nil {
+		return err
+	}
+	for _, sm := range submodules {
+		_, err := fmt.Fprintf(writer, "# submodule: %s\n", sm)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
 	return show(bs, we, writer)
 }
 
+// Pathspecs returns pathspecs which the diff of this ghost branch pulled on passed working env is limited to.
+// It returns an empty slice if the diff covers the whole tree.
+func (bs DiffBranch) Pathspecs(we WorkingEnv) ([]string, errors.GitGhostError) {
+	content, err := os.ReadFile(filepath.Join(we.GhostDir, PathspecFileName))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pathspecs := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if line != "" {
+			pathspecs = append(pathspecs, line)
+		}
+	}
+	return pathspecs, nil
+}
+
 // Apply applies contents(diff or patch) of this ghost branch on passed working env
-func (bs DiffBranch) Apply(we WorkingEnv) errors.GitGhostError {
-	err := apply(bs, we, bs.CommitHashFrom)
+func (bs DiffBranch) Apply(we WorkingEnv) (FileApplyResults, errors.GitGhostError) {
+	pathspecs, err := bs.Pathspecs(we)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	if len(pathspecs) > 0 {
+		log.WithFields(log.Fields{
+			"branch":    bs.BranchName(),
+			"pathspecs": pathspecs,
+		}).Info("applying diff limited to pathspecs")
+	}
+	indexPatchFile := filepath.Join(we.GhostDir, IndexPatchFileName)
+	_, statErr := os.Stat(indexPatchFile)
+	if statErr == nil && we.ThreeWay {
+		// 3-way merge stages its results, which overwrites the staging state anyway
+		log.WithFields(log.Fields{
+			"branch": bs.BranchName(),
+		}).Warn("staged changes are not restored separately with 3-way merge")
+	} else if statErr == nil {
+		// Check both patches first not to leave the index half-modified on failure
+		err = git.CheckDiffPatchFileToIndex(we.SrcDir, indexPatchFile)
+		if err != nil {
+			return nil, err
+		}
+		err = git.CheckDiffPatchFile(we.SrcDir, filepath.Join(we.GhostDir, bs.FileName()))
+		if err != nil {
+			return nil, err
+		}
+		// Restore the staging state first, and then the whole diff on the working tree
+		log.WithFields(log.Fields{
+			"branch": bs.BranchName(),
+		}).Info("applying staged changes to the index")
+		err = git.ApplyDiffPatchFileToIndex(we.SrcDir, indexPatchFile)
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(statErr) {
+		return nil, errors.WithStack(statErr)
+	}
+	// Read it before pulling ghost branches of submodules on the same working env
+	submodules, err := readSubmoduleGhosts(we.GhostDir)
+	if err != nil {
+		return nil, err
+	}
+	results, err := apply(bs, we, bs.CommitHashFrom)
+	if err != nil {
+		return nil, err
+	}
+	submoduleResults, err := applySubmoduleGhosts(we, bs.Prefix, submodules)
+	if err != nil {
+		return nil, err
+	}
+	return append(results, submoduleResults...), nil
 }
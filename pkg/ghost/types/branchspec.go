@@ -17,6 +17,8 @@ package types
 import (
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
@@ -32,7 +34,8 @@ import (
 //
 // GhostBranchSpec is a specification for creating ghost branch
 type GhostBranchSpec interface {
-	// CreateBranch create a ghost branch on WorkingEnv and returns a GhostBranch object
+	// CreateBranch creates contents of a ghost branch on WorkingEnv and returns a GhostBranch object.
+	// The contents are not committed until CommitBranch is called.
 	CreateBranch(we WorkingEnv) (GhostBranch, errors.GitGhostError)
 }
 
@@ -47,12 +50,16 @@ type PullableGhostBranchSpec interface {
 // ensuring interfaces
 var _ GhostBranchSpec = CommitsBranchSpec{}
 var _ GhostBranchSpec = DiffBranchSpec{}
+var _ GhostBranchSpec = StashBranchSpec{}
 var _ PullableGhostBranchSpec = CommitsBranchSpec{}
 var _ PullableGhostBranchSpec = PullableDiffBranchSpec{}
 
 // Constants
 const maxSymlinkDepth = 3
 
+// GhostIgnoreFilename is the name of a file listing pathspecs excluded from diff ghost branches
+const GhostIgnoreFilename = ".ghostignore"
+
 // CommitsBranchSpec is a spec for creating local base branch
 type CommitsBranchSpec struct {
 	Prefix         string
@@ -66,6 +73,26 @@ type DiffBranchSpec struct {
 	CommittishFrom    string
 	IncludedFilepaths []string
 	FollowSymlinks    bool
+	// IncludeIgnored includes files ignored by .gitignore when expanding directories and glob patterns
+	IncludeIgnored bool
+	// ExcludedPathspecs are pathspecs of files not to be included in the diff.
+	// Pathspecs listed in .ghostignore in the source directory are also excluded.
+	ExcludedPathspecs []string
+	// Pathspecs limit the diff to matched paths.  Empty pathspecs mean the whole tree.
+	Pathspecs []string
+	// SeparateStaged stores staged and unstaged changes separately in addition to the whole diff
+	// so that the staging state can be restored on pull
+	SeparateStaged bool
+	// RecurseSubmodules creates ghost branches of changed submodules linked to the diff
+	// instead of recording changes of gitlinks in the diff
+	RecurseSubmodules bool
+}
+
+// StashBranchSpec is a spec for creating local mod branch from a stash entry
+type StashBranchSpec struct {
+	Prefix string
+	// Stash is a stash entry like "stash@{0}"
+	Stash string
 }
 
 // PullableDiffBranchSpec is a spec for pulling local base branch
@@ -96,16 +123,23 @@ func (bs CommitsBranchSpec) Resolve(srcDir string) (*CommitsBranchSpec, errors.G
 }
 
 // PullBranch pulls a ghost branch on from ghost repo in WorkingEnv and returns a GhostBranch object
+//
+// CommittishTo doesn't have to exist in the source directory if it is a full commit hash
+// because the ghost branch brings it.
 func (bs CommitsBranchSpec) PullBranch(we WorkingEnv) (GhostBranch, errors.GitGhostError) {
-	resolved, err := bs.Resolve(we.SrcDir)
+	err := git.ValidateCommittish(we.SrcDir, bs.CommittishFrom)
+	if err != nil {
+		return nil, err
+	}
+	commitHashTo, err := ResolveCommittishOrHash(we.SrcDir, bs.CommittishTo)
 	if err != nil {
 		return nil, err
 	}
 
 	branch := &CommitsBranch{
-		Prefix:         resolved.Prefix,
-		CommitHashFrom: resolved.CommittishFrom,
-		CommitHashTo:   resolved.CommittishTo,
+		Prefix:         bs.Prefix,
+		CommitHashFrom: resolveCommittishOr(we.SrcDir, bs.CommittishFrom),
+		CommitHashTo:   commitHashTo,
 	}
 	err = pull(branch, we)
 	if err != nil {
@@ -114,47 +148,44 @@ func (bs CommitsBranchSpec) PullBranch(we WorkingEnv) (GhostBranch, errors.GitGh
 	return branch, nil
 }
 
-// CreateBranch create a ghost branch on WorkingEnv and returns a GhostBranch object
-func (bs CommitsBranchSpec) CreateBranch(we WorkingEnv) (GhostBranch, errors.GitGhostError) {
-	dstDir := we.GhostDir
-	srcDir := we.SrcDir
-	resolved, ggerr := bs.Resolve(we.SrcDir)
+// Branch returns a GhostBranch object to be created without creating its contents.
+// Unlike a diff ghost branch, its name doesn't depend on its contents.
+func (bs CommitsBranchSpec) Branch(srcDir string) (*CommitsBranch, errors.GitGhostError) {
+	resolved, ggerr := bs.Resolve(srcDir)
 	if ggerr != nil {
 		return nil, ggerr
 	}
-
-	commitHashFrom := resolved.CommittishFrom
-	commitHashTo := resolved.CommittishTo
-	branch := CommitsBranch{
+	return &CommitsBranch{
 		Prefix:         resolved.Prefix,
-		CommitHashFrom: commitHashFrom,
-		CommitHashTo:   commitHashTo,
+		CommitHashFrom: resolved.CommittishFrom,
+		CommitHashTo:   resolved.CommittishTo,
+	}, nil
+}
+
+// CreateBranch creates contents of a ghost branch on WorkingEnv and returns a GhostBranch object
+func (bs CommitsBranchSpec) CreateBranch(we WorkingEnv) (GhostBranch, errors.GitGhostError) {
+	branch, ggerr := bs.Branch(we.SrcDir)
+	if ggerr != nil {
+		return nil, ggerr
 	}
-	tmpFile, err := os.CreateTemp("", "git-ghost-local-base")
+	contentDir, err := we.createContentDir(branch)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	util.LogDeferredError(tmpFile.Close)
-	defer util.LogDeferredError(func() error { return os.Remove(tmpFile.Name()) })
-	ggerr = git.CreateDiffBundleFile(srcDir, tmpFile.Name(), commitHashFrom, commitHashTo)
+	ggerr = git.CreateDiffBundleFile(we.SrcDir, filepath.Join(contentDir, branch.FileName()), branch.CommitHashFrom, branch.CommitHashTo)
 	if ggerr != nil {
 		return nil, ggerr
 	}
-	err = os.Rename(tmpFile.Name(), filepath.Join(dstDir, branch.FileName()))
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-
-	ggerr = git.CreateOrphanBranch(dstDir, branch.BranchName())
+	manifest, ggerr := newManifest(we, nil)
 	if ggerr != nil {
 		return nil, ggerr
 	}
-	ggerr = git.CommitFile(dstDir, branch.FileName(), "Create ghost commit")
+	ggerr = writeManifest(contentDir, manifest)
 	if ggerr != nil {
 		return nil, ggerr
 	}
 
-	return &branch, nil
+	return branch, nil
 }
 
 // Resolve resolves committish in DiffBranchSpec as full commit hash values
@@ -165,9 +196,14 @@ func (bs DiffBranchSpec) Resolve(srcDir string) (*DiffBranchSpec, errors.GitGhos
 	}
 	commitHashFrom := resolveCommittishOr(srcDir, bs.CommittishFrom)
 
+	expandedFilepaths, err := expandFilepaths(srcDir, bs.IncludedFilepaths, bs.IncludeIgnored)
+	if err != nil {
+		return nil, err
+	}
+
 	var errs error
-	includedFilepaths := make([]string, 0, len(bs.IncludedFilepaths))
-	for _, p := range bs.IncludedFilepaths {
+	includedFilepaths := make([]string, 0, len(expandedFilepaths))
+	for _, p := range expandedFilepaths {
 		resolved, err := resolveFilepath(srcDir, p)
 		if err != nil {
 			errs = multierror.Append(errs, err)
@@ -207,67 +243,239 @@ func (bs DiffBranchSpec) Resolve(srcDir string) (*DiffBranchSpec, errors.GitGhos
 		return nil, errors.WithStack(errs)
 	}
 	if len(includedFilepaths) > 0 {
+		// Sort them so that the same set of files always produces the same DiffHash
 		includedFilepaths = util.UniqueStringSlice(includedFilepaths)
+		sort.Strings(includedFilepaths)
+	}
+
+	excludedPathspecs, err := ReadGhostIgnore(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	excludedPathspecs = append(excludedPathspecs, bs.ExcludedPathspecs...)
+	if len(excludedPathspecs) > 0 {
+		excludedPathspecs = util.UniqueStringSlice(excludedPathspecs)
+		sort.Strings(excludedPathspecs)
+	}
+	pathspecs := []string{}
+	if len(bs.Pathspecs) > 0 {
+		pathspecs = util.UniqueStringSlice(bs.Pathspecs)
+		sort.Strings(pathspecs)
+	}
+	includedFilepaths, err = git.FilterFiles(srcDir, includedFilepaths, pathspecs, excludedPathspecs)
+	if err != nil {
+		return nil, err
 	}
 
 	return &DiffBranchSpec{
 		Prefix:            bs.Prefix,
 		CommittishFrom:    commitHashFrom,
 		IncludedFilepaths: includedFilepaths,
+		ExcludedPathspecs: excludedPathspecs,
+		Pathspecs:         pathspecs,
+		SeparateStaged:    bs.SeparateStaged,
+		RecurseSubmodules: bs.RecurseSubmodules,
 	}, nil
 }
 
-// CreateBranch create a ghost branch on WorkingEnv and returns a GhostBranch object
+// ReadGhostIgnore returns pathspecs listed in .ghostignore at the top of the working tree containing dir.
+// Empty lines and lines starting with '#' are ignored.
+func ReadGhostIgnore(dir string) ([]string, errors.GitGhostError) {
+	topDir, ggerr := git.GetTopLevelDir(dir)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	content, err := os.ReadFile(filepath.Join(topDir, GhostIgnoreFilename))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pathspecs := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pathspecs = append(pathspecs, line)
+	}
+	return pathspecs, nil
+}
+
+// CreateBranch creates contents of a ghost branch on WorkingEnv and returns a GhostBranch object
 func (bs DiffBranchSpec) CreateBranch(we WorkingEnv) (GhostBranch, errors.GitGhostError) {
-	dstDir := we.GhostDir
 	srcDir := we.SrcDir
 	resolved, ggerr := bs.Resolve(we.SrcDir)
 	if ggerr != nil {
 		return nil, ggerr
 	}
 	commitHashFrom := resolved.CommittishFrom
-	tmpFile, err := os.CreateTemp("", "git-ghost-local-mod")
+	stagingDir, err := os.MkdirTemp(we.GhostDir, "git-ghost-local-mod")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	util.LogDeferredError(tmpFile.Close)
-	defer util.LogDeferredError(func() error { return os.Remove(tmpFile.Name()) })
-	err = git.CreateDiffPatchFile(srcDir, tmpFile.Name(), commitHashFrom)
+	defer util.LogDeferredError(func() error { return os.RemoveAll(stagingDir) })
+
+	diffOpts := []string{}
+	if resolved.RecurseSubmodules {
+		// Changes of submodules are stored in their own ghost branches
+		diffOpts = append(diffOpts, "--ignore-submodules=all")
+	}
+	patchFile := filepath.Join(stagingDir, DiffBranch{}.FileName())
+	err = git.CreateDiffPatchFile(srcDir, patchFile, commitHashFrom, resolved.Pathspecs, resolved.ExcludedPathspecs, diffOpts...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	if len(bs.IncludedFilepaths) > 0 {
-		err = git.AppendNonIndexedDiffFiles(srcDir, tmpFile.Name(), resolved.IncludedFilepaths)
+	// Scope of the diff makes a different ghost even if the patch is the same
+	hashExtras := make([]string, 0, len(resolved.Pathspecs)+len(resolved.ExcludedPathspecs)+1)
+	for _, p := range resolved.Pathspecs {
+		hashExtras = append(hashExtras, "pathspec:"+p)
+	}
+	for _, p := range resolved.ExcludedPathspecs {
+		hashExtras = append(hashExtras, "exclude:"+p)
+	}
+
+	if resolved.SeparateStaged {
+		indexPatchFile := filepath.Join(stagingDir, IndexPatchFileName)
+		err = git.CreateIndexDiffPatchFile(srcDir, indexPatchFile, commitHashFrom, resolved.Pathspecs, resolved.ExcludedPathspecs, diffOpts...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		// The same diff with different staging state makes a different ghost
+		indexHash, err := hash.GenerateFileContentHash(indexPatchFile)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		hashExtras = append(hashExtras, "index:"+indexHash)
+	}
+
+	if len(resolved.IncludedFilepaths) > 0 {
+		err = git.AppendNonIndexedDiffFiles(srcDir, patchFile, resolved.IncludedFilepaths)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 	}
 
-	hash, err := hash.GenerateFileContentHash(tmpFile.Name())
-	if err != nil {
-		return nil, errors.WithStack(err)
+	if len(resolved.Pathspecs) > 0 {
+		err = os.WriteFile(filepath.Join(stagingDir, PathspecFileName), []byte(strings.Join(resolved.Pathspecs, "\n")+"\n"), 0600)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if resolved.RecurseSubmodules {
+		paths, err := git.ListSubmodulePaths(srcDir)
+		if err != nil {
+			return nil, err
+		}
+		paths, err = git.FilterFiles(srcDir, paths, resolved.Pathspecs, resolved.ExcludedPathspecs)
+		if err != nil {
+			return nil, err
+		}
+		submodules, err := createSubmoduleGhosts(we, resolved.Prefix, commitHashFrom, paths)
+		if err != nil {
+			return nil, err
+		}
+		if len(submodules) > 0 {
+			err = writeSubmoduleGhosts(stagingDir, submodules)
+			if err != nil {
+				return nil, err
+			}
+			for _, sm := range submodules {
+				hashExtras = append(hashExtras, "submodule:"+sm.String())
+			}
+		}
+	}
+
+	includedFiles := make([]string, 0, len(resolved.IncludedFilepaths))
+	for _, p := range resolved.IncludedFilepaths {
+		includedFiles = append(includedFiles, filepath.ToSlash(p))
+	}
+	manifest, ggerr := newManifest(we, includedFiles)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	ggerr = writeManifest(stagingDir, manifest)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+
+	return createDiffBranch(we, resolved.Prefix, commitHashFrom, stagingDir, hashExtras)
+}
+
+// createDiffBranch creates contents of a DiffBranch from files in stagingDir.
+// Its DiffHash is generated from the patch file in stagingDir and hashExtras.
+func createDiffBranch(we WorkingEnv, prefix, commitHashFrom, stagingDir string, hashExtras []string) (*DiffBranch, errors.GitGhostError) {
+	hash, ggerr := hash.GenerateFileContentHash(filepath.Join(stagingDir, DiffBranch{}.FileName()), hashExtras...)
+	if ggerr != nil {
+		return nil, ggerr
 	}
 	branch := DiffBranch{
-		Prefix:         resolved.Prefix,
+		Prefix:         prefix,
 		CommitHashFrom: commitHashFrom,
 		DiffHash:       hash,
 	}
-	err = os.Rename(tmpFile.Name(), filepath.Join(dstDir, branch.FileName()))
+	contentDir, ggerr := we.createContentDir(branch)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	entries, err := os.ReadDir(stagingDir)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	for _, entry := range entries {
+		err = os.Rename(filepath.Join(stagingDir, entry.Name()), filepath.Join(contentDir, entry.Name()))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return &branch, nil
+}
 
-	err = git.CreateOrphanBranch(dstDir, branch.BranchName())
+// CreateBranch creates contents of a ghost branch on WorkingEnv and returns a GhostBranch object
+//
+// The branch is a DiffBranch whose diff is from the base commit of the stash entry.
+func (bs StashBranchSpec) CreateBranch(we WorkingEnv) (GhostBranch, errors.GitGhostError) {
+	srcDir := we.SrcDir
+	err := git.ValidateCommittish(srcDir, bs.Stash)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
-	err = git.CommitFile(dstDir, branch.FileName(), "Create ghost commit")
+	// A stash entry is a merge commit of its base commit and the index state
+	_, err = git.ResolveCommittish(srcDir, bs.Stash+"^2")
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, errors.Errorf("%s is not a stash entry", bs.Stash)
+	}
+	commitHashFrom, err := git.ResolveCommittish(srcDir, bs.Stash+"^1")
+	if err != nil {
+		return nil, err
+	}
+	stagingDir, serr := os.MkdirTemp(we.GhostDir, "git-ghost-local-mod")
+	if serr != nil {
+		return nil, errors.WithStack(serr)
 	}
+	defer util.LogDeferredError(func() error { return os.RemoveAll(stagingDir) })
 
-	return &branch, nil
+	err = git.CreateStashDiffPatchFile(srcDir, filepath.Join(stagingDir, DiffBranch{}.FileName()), bs.Stash)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := newManifest(we, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = writeManifest(stagingDir, manifest)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := createDiffBranch(we, bs.Prefix, commitHashFrom, stagingDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return branch, nil
 }
 
 // Resolve resolves committish in PullableDiffBranchSpec as full commit hash values
@@ -286,14 +494,17 @@ func (bs PullableDiffBranchSpec) Resolve(srcDir string) (*PullableDiffBranchSpec
 }
 
 // PullBranch pulls a ghost branch on from ghost repo in WorkingEnv and returns a GhostBranch object
+//
+// CommittishFrom doesn't have to exist in the source directory if it is a full commit hash,
+// e.g. when commits pulled just before are applied as different commits.
 func (bs PullableDiffBranchSpec) PullBranch(we WorkingEnv) (GhostBranch, errors.GitGhostError) {
-	resolved, err := bs.Resolve(we.SrcDir)
+	commitHashFrom, err := ResolveCommittishOrHash(we.SrcDir, bs.CommittishFrom)
 	if err != nil {
 		return nil, err
 	}
 	branch := &DiffBranch{
-		Prefix:         resolved.Prefix,
-		CommitHashFrom: resolved.CommittishFrom,
+		Prefix:         bs.Prefix,
+		CommitHashFrom: commitHashFrom,
 		DiffHash:       bs.DiffHash,
 	}
 	err = pull(branch, we)
@@ -304,9 +515,15 @@ func (bs PullableDiffBranchSpec) PullBranch(we WorkingEnv) (GhostBranch, errors.
 }
 
 func pull(ghost GhostBranch, we WorkingEnv) errors.GitGhostError {
+	err := we.FetchBranches(ghost.BranchName())
+	if err != nil {
+		return err
+	}
 	return git.ResetHardToBranch(we.GhostDir, git.ORIGIN+"/"+ghost.BranchName())
 }
 
+var regexpFullHash = regexp.MustCompile("^[0-9a-f]{40}$")
+
 func resolveCommittishOr(srcDir string, committishToResolve string) string {
 	resolved, err := git.ResolveCommittish(srcDir, committishToResolve)
 	if err != nil {
@@ -319,6 +536,79 @@ func resolveCommittishOr(srcDir string, committishToResolve string) string {
 	return resolved
 }
 
+// ResolveCommittishOrHash resolves committish on srcDir, or returns it as it is if it is a full commit hash not on srcDir
+func ResolveCommittishOrHash(srcDir, committish string) (string, errors.GitGhostError) {
+	exists, err := git.CommittishExists(srcDir, committish)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return git.ResolveCommittish(srcDir, committish)
+	}
+	if !regexpFullHash.MatchString(committish) {
+		return "", errors.Errorf("%s does not exist", committish)
+	}
+	return committish, nil
+}
+
+// expandFilepaths expands directories and glob patterns in paths to untracked files in them.
+// Other paths are returned as they are.
+func expandFilepaths(dir string, paths []string, includeIgnored bool) ([]string, errors.GitGhostError) {
+	var errs error
+	expanded := make([]string, 0, len(paths))
+	for _, p := range paths {
+		absp := p
+		if !filepath.IsAbs(p) {
+			absp = filepath.Join(dir, p)
+		}
+		var pathspec string
+		if strings.ContainsAny(p, "*?[") {
+			pathspec = ":(glob)"
+		} else {
+			isdir, err := util.IsDir(absp)
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			if !isdir {
+				expanded = append(expanded, p)
+				continue
+			}
+			pathspec = ":(literal)"
+		}
+
+		relp, err := filepath.Rel(dir, absp)
+		if err != nil {
+			errs = multierror.Append(errs, errors.WithStack(err))
+			continue
+		}
+		if strings.HasPrefix(relp, "../") {
+			errs = multierror.Append(errs, errors.Errorf("%s is not located in the source directory", p))
+			continue
+		}
+		files, ggerr := git.ListUntrackedFiles(dir, includeIgnored, pathspec+filepath.ToSlash(relp))
+		if ggerr != nil {
+			errs = multierror.Append(errs, ggerr)
+			continue
+		}
+		if len(files) == 0 {
+			errs = multierror.Append(errs, errors.Errorf("no untracked files matched: %s", p))
+			continue
+		}
+		log.WithFields(log.Fields{
+			"path":  p,
+			"files": files,
+		}).Debug("expanded path")
+		for _, f := range files {
+			expanded = append(expanded, filepath.Join(dir, filepath.FromSlash(f)))
+		}
+	}
+	if errs != nil {
+		return nil, errors.WithStack(errs)
+	}
+	return expanded, nil
+}
+
 func resolveFilepath(dir, p string) (string, errors.GitGhostError) {
 	absp := p
 	if !filepath.IsAbs(p) {
@@ -337,7 +627,7 @@ func resolveFilepath(dir, p string) (string, errors.GitGhostError) {
 	if strings.HasPrefix(relp, "../") {
 		return "", errors.Errorf("%s is not located in the source directory", p)
 	}
-	isdir, err := util.IsDir(relp)
+	isdir, err := util.IsDir(absp)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
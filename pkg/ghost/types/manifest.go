@@ -0,0 +1,173 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// ManifestFileName is a file name in a ghost branch containing its Manifest
+const ManifestFileName = "ghost.json"
+
+// Manifest is metadata of a ghost branch recorded when it is created
+type Manifest struct {
+	// Author is a user who created the ghost branch like "Name <email>"
+	Author string `json:"author,omitempty" yaml:"author,omitempty"`
+	// CreatedAt is a time when the ghost branch was created
+	CreatedAt time.Time `json:"createdAt" yaml:"createdAt"`
+	// Host is a host name where the ghost branch was created
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+	// Remotes are URLs of remotes of the source directory keyed by their names
+	Remotes map[string]string `json:"remotes,omitempty" yaml:"remotes,omitempty"`
+	// Branch is a branch checked out on the source directory.  Empty on detached HEAD.
+	Branch string `json:"branch,omitempty" yaml:"branch,omitempty"`
+	// IncludedFiles are non-indexed files included in the ghost branch
+	IncludedFiles []string `json:"includedFiles,omitempty" yaml:"includedFiles,omitempty"`
+	// Labels are user-supplied key-value pairs to identify the ghost branch
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Message is a user-supplied note of the ghost branch, which is also used as its commit message
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+	// Version is a version of git-ghost which created the ghost branch
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Revision is a revision of git-ghost which created the ghost branch
+	Revision string `json:"revision,omitempty" yaml:"revision,omitempty"`
+}
+
+// newManifest creates a Manifest of a ghost branch created on WorkingEnv
+func newManifest(we WorkingEnv, includedFiles []string) (*Manifest, errors.GitGhostError) {
+	remotes, ggerr := git.ListRemoteURLs(we.SrcDir)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	for name, remote := range remotes {
+		remotes[name] = RedactURL(remote)
+	}
+	branch, ggerr := git.GetCurrentBranchName(we.SrcDir)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	author := ""
+	if we.GhostUserName != "" || we.GhostUserEmail != "" {
+		author = fmt.Sprintf("%s <%s>", we.GhostUserName, we.GhostUserEmail)
+	}
+	return &Manifest{
+		Author:        author,
+		CreatedAt:     time.Now().UTC().Truncate(time.Second),
+		Host:          host,
+		Remotes:       remotes,
+		Branch:        branch,
+		IncludedFiles: includedFiles,
+		Version:       we.GitGhostVersion,
+		Revision:      we.GitGhostRevision,
+	}, nil
+}
+
+// RedactURL removes credentials from a remote URL
+func RedactURL(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.User == nil {
+		return remote
+	}
+	u.User = nil
+	return u.String()
+}
+
+// MatchLabels returns true if the manifest has all labels in selector
+func (manifest *Manifest) MatchLabels(selector map[string]string) bool {
+	for key, value := range selector {
+		if manifest == nil {
+			return false
+		}
+		v, ok := manifest.Labels[key]
+		if !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// AnnotateManifest sets labels and message to the manifest of ghost created on WorkingEnv
+func AnnotateManifest(ghost GhostBranch, we WorkingEnv, labels map[string]string, message string) errors.GitGhostError {
+	dir := we.contentDir(ghost)
+	manifest, ggerr := readManifest(dir)
+	if ggerr != nil {
+		return ggerr
+	}
+	if manifest == nil {
+		return errors.Errorf("%s has no manifest", ghost.BranchName())
+	}
+	if len(labels) > 0 {
+		manifest.Labels = labels
+	}
+	if message != "" {
+		manifest.Message = message
+	}
+	return writeManifest(dir, manifest)
+}
+
+// readManifest reads the Manifest in dir.  It returns nil if dir has no manifest.
+func readManifest(dir string) (*Manifest, errors.GitGhostError) {
+	content, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var manifest Manifest
+	err = json.Unmarshal(content, &manifest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &manifest, nil
+}
+
+func writeManifest(dir string, manifest *Manifest) errors.GitGhostError {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(filepath.Join(dir, ManifestFileName), append(content, '\n'), 0600))
+}
+
+// LoadManifest reads the Manifest of ghost fetched on WorkingEnv.
+// It returns nil if ghost has no manifest, which means it was created by an older git-ghost.
+func LoadManifest(we WorkingEnv, ghost GhostBranch) (*Manifest, errors.GitGhostError) {
+	content, ggerr := git.ReadFileOnRef(we.GhostDir, fmt.Sprintf("refs/remotes/%s/%s", git.ORIGIN, ghost.BranchName()), ManifestFileName)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	if content == nil {
+		return nil, nil
+	}
+	var manifest Manifest
+	err := json.Unmarshal(content, &manifest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &manifest, nil
+}
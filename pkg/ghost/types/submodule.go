@@ -0,0 +1,276 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SubmodulesFileName is a file name in a DiffBranch linking ghost branches of its submodules
+const SubmodulesFileName = "submodules.json"
+
+// SubmoduleGhost links ghost branches of a submodule to the DiffBranch of its parent
+//
+// Ghost branches of the submodule are
+// - a CommitsBranch for Base..Head if they differ
+// - a DiffBranch from Head whose hash is DiffHash if it is not empty
+// which have the same prefix as the parent.
+type SubmoduleGhost struct {
+	// Path is a path of the submodule in the parent
+	Path string `json:"path"`
+	// Base is a commit of the submodule recorded in the base commit of the parent
+	Base string `json:"base"`
+	// Head is HEAD of the submodule
+	Head string `json:"head"`
+	// DiffHash is a hash of the DiffBranch of the submodule.  Empty if the submodule has no local modification.
+	DiffHash string `json:"diffHash,omitempty"`
+}
+
+func (sm SubmoduleGhost) String() string {
+	return fmt.Sprintf("%s %s..%s %s", sm.Path, sm.Base, sm.Head, sm.DiffHash)
+}
+
+func (sm SubmoduleGhost) branches(prefix string) []GhostBranch {
+	branches := []GhostBranch{}
+	if sm.Base != sm.Head {
+		branches = append(branches, CommitsBranch{
+			Prefix:         prefix,
+			CommitHashFrom: sm.Base,
+			CommitHashTo:   sm.Head,
+		})
+	}
+	if sm.DiffHash != "" {
+		branches = append(branches, DiffBranch{
+			Prefix:         prefix,
+			CommitHashFrom: sm.Head,
+			DiffHash:       sm.DiffHash,
+		})
+	}
+	return branches
+}
+
+func submoduleWorkingEnv(we WorkingEnv, path string) WorkingEnv {
+	subWe := we
+	subWe.SrcDir = filepath.Join(we.SrcDir, filepath.FromSlash(path))
+	return subWe
+}
+
+// createSubmoduleGhosts creates contents of ghost branches of submodules at paths on WorkingEnv.
+// Submodules without any change from baseCommit of the parent are skipped.
+// Submodules not in baseCommit are also skipped because there is no base to apply their ghosts to.
+func createSubmoduleGhosts(we WorkingEnv, prefix, baseCommit string, paths []string) ([]SubmoduleGhost, errors.GitGhostError) {
+	submodules := []SubmoduleGhost{}
+	for _, path := range paths {
+		subWe := submoduleWorkingEnv(we, path)
+		if _, err := os.Stat(filepath.Join(subWe.SrcDir, ".git")); err != nil {
+			log.WithFields(log.Fields{
+				"path": path,
+			}).Debug("skipped uninitialized submodule")
+			continue
+		}
+		head, ggerr := git.ResolveCommittish(subWe.SrcDir, "HEAD")
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		base, ggerr := git.ResolveSubmoduleCommit(we.SrcDir, baseCommit, path)
+		if ggerr != nil {
+			log.WithFields(log.Fields{
+				"path":       path,
+				"baseCommit": baseCommit,
+			}).Warn("skipped submodule which does not exist in the base commit")
+			continue
+		}
+		sm := SubmoduleGhost{
+			Path: path,
+			Base: base,
+			Head: head,
+		}
+
+		if base != head {
+			_, ggerr := CommitsBranchSpec{
+				Prefix:         prefix,
+				CommittishFrom: base,
+				CommittishTo:   head,
+			}.CreateBranch(subWe)
+			if ggerr != nil {
+				return nil, ggerr
+			}
+		}
+		branch, ggerr := DiffBranchSpec{
+			Prefix:            prefix,
+			CommittishFrom:    head,
+			RecurseSubmodules: true,
+		}.CreateBranch(subWe)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		diffBranch := branch.(*DiffBranch)
+		empty, ggerr := isEmptyDiffBranch(we, *diffBranch)
+		if ggerr != nil {
+			return nil, ggerr
+		}
+		if !empty {
+			sm.DiffHash = diffBranch.DiffHash
+		}
+
+		if sm.Base == sm.Head && sm.DiffHash == "" {
+			continue
+		}
+		submodules = append(submodules, sm)
+	}
+	return submodules, nil
+}
+
+// isEmptyDiffBranch checks contents of a DiffBranch created on WorkingEnv has no change
+func isEmptyDiffBranch(we WorkingEnv, branch DiffBranch) (bool, errors.GitGhostError) {
+	contentDir := we.contentDir(branch)
+	fi, err := os.Stat(filepath.Join(contentDir, branch.FileName()))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if fi.Size() > 0 {
+		return false, nil
+	}
+	_, err = os.Stat(filepath.Join(contentDir, SubmodulesFileName))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	return false, errors.WithStack(err)
+}
+
+// CreatedSubmoduleBranches returns ghost branches of submodules created with ghost on WorkingEnv recursively
+func CreatedSubmoduleBranches(we WorkingEnv, ghost GhostBranch) ([]GhostBranch, errors.GitGhostError) {
+	diffBranch, ok := ghost.(*DiffBranch)
+	if !ok {
+		return []GhostBranch{}, nil
+	}
+	submodules, err := readSubmoduleGhosts(we.contentDir(diffBranch))
+	if err != nil {
+		return nil, err
+	}
+	branches := []GhostBranch{}
+	for _, sm := range submodules {
+		for _, branch := range sm.branches(diffBranch.Prefix) {
+			branches = append(branches, branch)
+			if nested, ok := branch.(DiffBranch); ok {
+				nestedBranches, err := CreatedSubmoduleBranches(we, &nested)
+				if err != nil {
+					return nil, err
+				}
+				branches = append(branches, nestedBranches...)
+			}
+		}
+	}
+	return branches, nil
+}
+
+func writeSubmoduleGhosts(dir string, submodules []SubmoduleGhost) errors.GitGhostError {
+	content, err := json.MarshalIndent(submodules, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(filepath.Join(dir, SubmodulesFileName), append(content, '\n'), 0600))
+}
+
+func readSubmoduleGhosts(dir string) ([]SubmoduleGhost, errors.GitGhostError) {
+	content, err := os.ReadFile(filepath.Join(dir, SubmodulesFileName))
+	if os.IsNotExist(err) {
+		return []SubmoduleGhost{}, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	submodules := []SubmoduleGhost{}
+	err = json.Unmarshal(content, &submodules)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return submodules, nil
+}
+
+// applySubmoduleGhosts checks out submodules and applies their ghost branches on WorkingEnv recursively.
+// Results per file are returned with paths relative to the parent if they are applied with 3-way merge.
+func applySubmoduleGhosts(we WorkingEnv, prefix string, submodules []SubmoduleGhost) (FileApplyResults, errors.GitGhostError) {
+	results := FileApplyResults{}
+	for _, sm := range submodules {
+		log.WithFields(log.Fields{
+			"path":     sm.Path,
+			"base":     sm.Base,
+			"head":     sm.Head,
+			"diffHash": sm.DiffHash,
+		}).Info("applying ghost branches of submodule")
+		subWe := submoduleWorkingEnv(we, sm.Path)
+		if _, err := os.Stat(filepath.Join(subWe.SrcDir, ".git")); err != nil {
+			ggerr := git.UpdateSubmodule(we.SrcDir, sm.Path)
+			if ggerr != nil {
+				return nil, ggerr
+			}
+		}
+
+		// Head might be available from the remote of the submodule.  Otherwise the commits ghost is applied on Base.
+		ggerr := git.CheckoutDetached(subWe.SrcDir, sm.Head)
+		if ggerr != nil {
+			log.WithFields(log.Fields{
+				"path":  sm.Path,
+				"head":  sm.Head,
+				"error": ggerr.Error(),
+			}).Debug("head of submodule is not available. applying commits ghost on its base")
+			ggerr = git.CheckoutDetached(subWe.SrcDir, sm.Base)
+			if ggerr != nil {
+				return nil, ggerr
+			}
+			branch := CommitsBranch{
+				Prefix:         prefix,
+				CommitHashFrom: sm.Base,
+				CommitHashTo:   sm.Head,
+			}
+			ggerr = pull(branch, subWe)
+			if ggerr != nil {
+				return nil, ggerr
+			}
+			subResults, ggerr := branch.Apply(subWe)
+			if ggerr != nil {
+				return nil, ggerr
+			}
+			results = append(results, subResults.inDirectory(sm.Path)...)
+		}
+
+		if sm.DiffHash != "" {
+			branch := DiffBranch{
+				Prefix:         prefix,
+				CommitHashFrom: sm.Head,
+				DiffHash:       sm.DiffHash,
+			}
+			ggerr = pull(branch, subWe)
+			if ggerr != nil {
+				return nil, ggerr
+			}
+			subResults, ggerr := branch.Apply(subWe)
+			if ggerr != nil {
+				return nil, ggerr
+			}
+			results = append(results, subResults.inDirectory(sm.Path)...)
+		}
+	}
+	return results, nil
+}
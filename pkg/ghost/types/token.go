@@ -0,0 +1,179 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// TokenScheme is a scheme of ghost reference tokens
+const TokenScheme = "ghost"
+
+var regexpHash = regexp.MustCompile("^[0-9a-f]+$")
+
+// Token is a single reference to ghost branches containing everything to restore them like
+// "ghost://<ghost repo>?prefix=<prefix>&source=<source repo>#<remote base>..<local base>/<diff hash>".
+//
+// "<remote base>.." is omitted without commits, and "/<diff hash>" is omitted without diff.
+type Token struct {
+	// GhostRepo is a URL of the ghost repository
+	GhostRepo string
+	// Prefix is a prefix of ghost branches
+	Prefix string
+	// SourceRepo is a URL of the source repository.  Empty if unknown.
+	SourceRepo string
+	// RemoteBase is a commit hash from which commits are contained.  Empty without commits.
+	RemoteBase string
+	// LocalBase is a commit hash to which commits are contained and from which diff is contained
+	LocalBase string
+	// DiffHash is a hash value of diff.  Empty without diff.
+	DiffHash string
+}
+
+// NewToken returns a Token referring to branches.  Credentials in URLs are removed.
+func NewToken(ghostRepo, sourceRepo string, branches ...GhostBranch) (*Token, errors.GitGhostError) {
+	token := Token{
+		GhostRepo:  RedactURL(ghostRepo),
+		SourceRepo: RedactURL(sourceRepo),
+	}
+	for _, branch := range branches {
+		switch b := branch.(type) {
+		case *CommitsBranch:
+			token.Prefix = b.Prefix
+			token.RemoteBase = b.CommitHashFrom
+			token.LocalBase = b.CommitHashTo
+		case *DiffBranch:
+			token.Prefix = b.Prefix
+			token.LocalBase = b.CommitHashFrom
+			token.DiffHash = b.DiffHash
+		}
+	}
+	if token.LocalBase == "" {
+		return nil, errors.New("no ghost branch to refer to")
+	}
+	return &token, nil
+}
+
+// IsToken returns true if s looks like a Token
+func IsToken(s string) bool {
+	return strings.HasPrefix(s, TokenScheme+"://")
+}
+
+// ParseToken parses s into a Token
+func ParseToken(s string) (*Token, errors.GitGhostError) {
+	if !IsToken(s) {
+		return nil, errors.Errorf("token must start with %s://: %s", TokenScheme, s)
+	}
+	rest := strings.TrimPrefix(s, TokenScheme+"://")
+	var token Token
+
+	i := strings.Index(rest, "#")
+	if i < 0 {
+		return nil, errors.Errorf("token has no ghost branches: %s", s)
+	}
+	rest, fragment := rest[:i], rest[i+1:]
+	if j := strings.Index(fragment, "/"); j >= 0 {
+		fragment, token.DiffHash = fragment[:j], fragment[j+1:]
+		if !regexpHash.MatchString(token.DiffHash) {
+			return nil, errors.Errorf("token has invalid diff hash: %s", s)
+		}
+	}
+	if j := strings.Index(fragment, ".."); j >= 0 {
+		token.RemoteBase, fragment = fragment[:j], fragment[j+2:]
+		if !regexpHash.MatchString(token.RemoteBase) {
+			return nil, errors.Errorf("token has invalid remote base: %s", s)
+		}
+	}
+	token.LocalBase = fragment
+	if !regexpHash.MatchString(token.LocalBase) {
+		return nil, errors.Errorf("token has invalid local base: %s", s)
+	}
+	if token.RemoteBase == "" && token.DiffHash == "" {
+		return nil, errors.Errorf("token has no ghost branches: %s", s)
+	}
+
+	if i := strings.Index(rest, "?"); i >= 0 {
+		query, err := url.ParseQuery(rest[i+1:])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		token.Prefix = query.Get("prefix")
+		token.SourceRepo = query.Get("source")
+		rest = rest[:i]
+	}
+	ghostRepo, err := url.PathUnescape(rest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	token.GhostRepo = ghostRepo
+	if token.GhostRepo == "" || token.Prefix == "" {
+		return nil, errors.Errorf("token must have ghost repo and prefix: %s", s)
+	}
+	return &token, nil
+}
+
+// String returns the token in the URI-like form which ParseToken parses
+func (token Token) String() string {
+	query := url.Values{}
+	query.Set("prefix", token.Prefix)
+	if token.SourceRepo != "" {
+		query.Set("source", token.SourceRepo)
+	}
+	fragment := token.LocalBase
+	if token.RemoteBase != "" {
+		fragment = fmt.Sprintf("%s..%s", token.RemoteBase, fragment)
+	}
+	if token.DiffHash != "" {
+		fragment = fmt.Sprintf("%s/%s", fragment, token.DiffHash)
+	}
+	return fmt.Sprintf("%s://%s?%s#%s", TokenScheme, url.PathEscape(token.GhostRepo), query.Encode(), fragment)
+}
+
+// CommitsBranchSpec returns a spec to pull commits in the token.  It returns nil without commits.
+func (token Token) CommitsBranchSpec() *CommitsBranchSpec {
+	if token.RemoteBase == "" {
+		return nil
+	}
+	return &CommitsBranchSpec{
+		Prefix:         token.Prefix,
+		CommittishFrom: token.RemoteBase,
+		CommittishTo:   token.LocalBase,
+	}
+}
+
+// PullableDiffBranchSpec returns a spec to pull diff in the token.  It returns nil without diff.
+func (token Token) PullableDiffBranchSpec() *PullableDiffBranchSpec {
+	if token.DiffHash == "" {
+		return nil
+	}
+	return &PullableDiffBranchSpec{
+		Prefix:         token.Prefix,
+		CommittishFrom: token.LocalBase,
+		DiffHash:       token.DiffHash,
+	}
+}
+
+// Base returns a commit hash which the source directory should be at before pulling the token
+func (token Token) Base() string {
+	if token.RemoteBase != "" {
+		return token.RemoteBase
+	}
+	return token.LocalBase
+}
@@ -15,9 +15,13 @@
 package types
 
 import (
+	"crypto/sha1"
+	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/pfnet-research/git-ghost/pkg/ghost/git"
+	"github.com/pfnet-research/git-ghost/pkg/util"
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 
 	log "github.com/sirupsen/logrus"
@@ -40,6 +44,15 @@ type WorkingEnvSpec struct {
 	GhostUserName string
 	// GhostUserEmail is a user email which is used in ghost working directories.
 	GhostUserEmail string
+	// GhostCacheDir is a root directory which git-ghost keeps bare mirrors of ghost repos in.
+	// Ghost branches are fetched directly from GhostRepo if it is empty.
+	GhostCacheDir string
+	// GitGhostVersion is a version of git-ghost recorded in manifests of ghost branches
+	GitGhostVersion string
+	// GitGhostRevision is a revision of git-ghost recorded in manifests of ghost branches
+	GitGhostRevision string
+	// ThreeWay makes ghost branches applied with 3-way merge, which leaves conflict markers on conflicts
+	ThreeWay bool
 }
 
 // WorkingEnv is initialized environment containing temporary local ghost repository
@@ -48,15 +61,8 @@ type WorkingEnv struct {
 	GhostDir string
 }
 
-func (weSpec WorkingEnvSpec) Initialize() (*WorkingEnv, errors.GitGhostError) {
-	ghostDir, err := os.MkdirTemp(weSpec.GhostWorkingDir, "git-ghost-")
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	ggerr := git.InitializeGitDir(ghostDir, weSpec.GhostRepo, "")
-	if ggerr != nil {
-		return nil, ggerr
-	}
+// GhostUser returns a user name and email used in ghost working directories
+func (weSpec WorkingEnvSpec) GhostUser() (string, string) {
 	ghostUserName := defaultGhostUserName
 	if weSpec.GhostUserName != "" {
 		ghostUserName = weSpec.GhostUserName
@@ -65,6 +71,21 @@ func (weSpec WorkingEnvSpec) Initialize() (*WorkingEnv, errors.GitGhostError) {
 	if weSpec.GhostUserEmail != "" {
 		ghostUserEmail = weSpec.GhostUserEmail
 	}
+	return ghostUserName, ghostUserEmail
+}
+
+// Initialize creates an empty local ghost repository whose origin is GhostRepo.
+// Ghost branches are not fetched until FetchBranches is called.
+func (weSpec WorkingEnvSpec) Initialize() (*WorkingEnv, errors.GitGhostError) {
+	ghostDir, err := os.MkdirTemp(weSpec.GhostWorkingDir, "git-ghost-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	ggerr := git.InitializeGitDir(ghostDir, weSpec.GhostRepo)
+	if ggerr != nil {
+		return nil, ggerr
+	}
+	ghostUserName, ghostUserEmail := weSpec.GhostUser()
 	ggerr = git.SetUserConfig(ghostDir, ghostUserName, ghostUserEmail)
 	if ggerr != nil {
 		return nil, ggerr
@@ -72,7 +93,7 @@ func (weSpec WorkingEnvSpec) Initialize() (*WorkingEnv, errors.GitGhostError) {
 
 	log.WithFields(log.Fields{
 		"dir": ghostDir,
-	}).Debug("ghost repo was cloned")
+	}).Debug("ghost repo was initialized")
 
 	return &WorkingEnv{
 		WorkingEnvSpec: weSpec,
@@ -83,3 +104,107 @@ func (weSpec WorkingEnvSpec) Initialize() (*WorkingEnv, errors.GitGhostError) {
 func (weSpec WorkingEnv) Clean() errors.GitGhostError {
 	return errors.WithStack(os.RemoveAll(weSpec.GhostDir))
 }
+
+// contentDir returns a directory in which contents of ghost are created before committed
+func (we WorkingEnv) contentDir(ghost GhostBranch) string {
+	return filepath.Join(we.GhostDir, filepath.FromSlash(ghost.BranchName()))
+}
+
+// createContentDir creates a directory returned by contentDir
+func (we WorkingEnv) createContentDir(ghost GhostBranch) (string, errors.GitGhostError) {
+	dir := we.contentDir(ghost)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return dir, nil
+}
+
+// FetchBranches fetches ghost branches from GhostRepo to the local ghost repository
+//
+// If GhostCacheDir is set, branches are fetched via the mirror of GhostRepo in it.
+// Only branches which the mirror doesn't have are fetched from GhostRepo
+// because ghost branches are never updated once they are pushed.
+// Branches deleted from GhostRepo are pruned from the mirror instead of being served from it.
+func (we WorkingEnv) FetchBranches(branchNames ...string) errors.GitGhostError {
+	if we.GhostCacheDir == "" {
+		return git.FetchBranches(we.GhostDir, branchNames...)
+	}
+	err := os.MkdirAll(we.GhostCacheDir, 0700)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	mirrorDir := filepath.Join(we.GhostCacheDir, fmt.Sprintf("%x.git", sha1.Sum([]byte(we.GhostRepo))))
+
+	// Lock the mirror to share it among concurrent git-ghost processes.
+	// It is held until branches are fetched from the mirror so that they are not pruned meanwhile.
+	unlock, ggerr := util.LockFile(mirrorDir + ".lock")
+	if ggerr != nil {
+		return ggerr
+	}
+	defer util.LogDeferredGitGhostError(unlock)
+
+	ggerr = we.updateMirror(mirrorDir, branchNames)
+	if ggerr != nil {
+		return errors.WithStack(ggerr)
+	}
+	return git.FetchBranchesFrom(we.GhostDir, mirrorDir, branchNames...)
+}
+
+// updateMirror fetches missing branches to the mirror of GhostRepo in mirrorDir, and prunes ones deleted from GhostRepo
+func (we WorkingEnv) updateMirror(mirrorDir string, branchNames []string) errors.GitGhostError {
+	_, err := os.Stat(mirrorDir)
+	if os.IsNotExist(err) {
+		ggerr := git.InitializeBareGitDir(mirrorDir, we.GhostRepo)
+		if ggerr != nil {
+			return ggerr
+		}
+		log.WithFields(log.Fields{
+			"dir":       mirrorDir,
+			"ghostRepo": we.GhostRepo,
+		}).Debug("ghost repo mirror was initialized")
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+
+	remoteBranchNames, ggerr := git.ListRemoteBranchNames(we.GhostRepo, branchNames)
+	if ggerr != nil {
+		return ggerr
+	}
+	remote := make(map[string]bool, len(remoteBranchNames))
+	for _, name := range remoteBranchNames {
+		remote[name] = true
+	}
+	cachedBranchNames, ggerr := git.ListBranchNames(mirrorDir)
+	if ggerr != nil {
+		return ggerr
+	}
+	cached := make(map[string]bool, len(cachedBranchNames))
+	for _, name := range cachedBranchNames {
+		cached[name] = true
+	}
+	missingBranchNames := []string{}
+	deletedBranchNames := []string{}
+	for _, name := range branchNames {
+		if !remote[name] {
+			if cached[name] {
+				deletedBranchNames = append(deletedBranchNames, name)
+			}
+		} else if !cached[name] {
+			missingBranchNames = append(missingBranchNames, name)
+		}
+	}
+	log.WithFields(log.Fields{
+		"dir":      mirrorDir,
+		"branches": deletedBranchNames,
+	}).Debug("pruning branches deleted from ghost repo in ghost repo mirror")
+	ggerr = git.DeleteBranches(mirrorDir, deletedBranchNames...)
+	if ggerr != nil {
+		return ggerr
+	}
+	log.WithFields(log.Fields{
+		"dir":      mirrorDir,
+		"branches": missingBranchNames,
+	}).Debug("fetching branches to ghost repo mirror")
+	return git.MirrorBranches(mirrorDir, missingBranchNames...)
+}
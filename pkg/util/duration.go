@@ -0,0 +1,47 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+var durationWithDaysPattern = regexp.MustCompile(`^([0-9]+)([dw])$`)
+
+// ParseDuration parses a duration string.
+// In addition to the format accepted by time.ParseDuration, it accepts days ("30d") and weeks ("2w").
+func ParseDuration(s string) (time.Duration, errors.GitGhostError) {
+	m := durationWithDaysPattern.FindStringSubmatch(s)
+	if len(m) > 0 {
+		n, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		unit := 24 * time.Hour
+		if m[2] == "w" {
+			unit = 7 * 24 * time.Hour
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return d, nil
+}
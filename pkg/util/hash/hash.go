@@ -23,7 +23,9 @@ import (
 	"github.com/pfnet-research/git-ghost/pkg/util/errors"
 )
 
-func GenerateFileContentHash(filepath string) (string, errors.GitGhostError) {
+// GenerateFileContentHash returns a hash of the content of filepath followed by extras.
+// Without extras, the hash is compatible with sha1sum of the file.
+func GenerateFileContentHash(filepath string, extras ...string) (string, errors.GitGhostError) {
 	// ref: https://pkg.go.dev/crypto/sha1#example-New-File
 	f, err := os.Open(filepath)
 	if err != nil {
@@ -34,5 +36,10 @@ func GenerateFileContentHash(filepath string) (string, errors.GitGhostError) {
 	if _, err := io.Copy(h, f); err != nil {
 		return "", errors.WithStack(err)
 	}
+	for _, extra := range extras {
+		if _, err := io.WriteString(h, "\x00"+extra); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
 	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
@@ -50,3 +50,25 @@ func TestHashCompatibility(t *testing.T) {
 	}
 	assert.Equal(t, oldHash, newHash)
 }
+
+func TestHashWithExtras(t *testing.T) {
+	tmpFile, err := os.CreateTemp(os.TempDir(), "tempfile-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	plain, err := hash.GenerateFileContentHash(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	withExtras, err := hash.GenerateFileContentHash(tmpFile.Name(), "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, plain, withExtras)
+	withOtherExtras, err := hash.GenerateFileContentHash(tmpFile.Name(), "ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, withExtras, withOtherExtras)
+}
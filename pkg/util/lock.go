@@ -0,0 +1,40 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"os"
+
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+// LockFile acquires an exclusive lock of a given file, creating it if it does not exist.
+// It blocks until the lock is acquired. Call the returned function to release the lock.
+func LockFile(path string) (func() errors.GitGhostError, errors.GitGhostError) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = lockFile(f)
+	if err != nil {
+		LogDeferredError(f.Close)
+		return nil, errors.WithStack(err)
+	}
+	unlock := func() errors.GitGhostError {
+		defer LogDeferredError(f.Close)
+		return errors.WithStack(unlockFile(f))
+	}
+	return unlock, nil
+}
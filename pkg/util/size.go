@@ -0,0 +1,52 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pfnet-research/git-ghost/pkg/util/errors"
+)
+
+var sizePattern = regexp.MustCompile(`^([0-9]+)(?:([KMG])I?)?B?$`)
+
+// ParseSize parses a size string in bytes like "512", "100K", "10M" or "1GiB".
+// Units are powers of 1024.
+func ParseSize(s string) (int64, errors.GitGhostError) {
+	m := sizePattern.FindStringSubmatch(strings.ToUpper(s))
+	if len(m) == 0 {
+		return 0, errors.Errorf("invalid size: %s", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	shift := uint(0)
+	switch m[2] {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	if n > math.MaxInt64>>shift {
+		return 0, errors.Errorf("too large size: %s", s)
+	}
+	return n << shift, nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Preferred Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util_test
+
+import (
+	"testing"
+
+	"github.com/pfnet-research/git-ghost/pkg/util"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"0":           0,
+		"512":         512,
+		"100K":        100 << 10,
+		"10m":         10 << 20,
+		"1GiB":        1 << 30,
+		"2MB":         2 << 20,
+		"8589934591G": 8589934591 << 30,
+	}
+	for s, expected := range cases {
+		n, err := util.ParseSize(s)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, n)
+	}
+
+	for _, s := range []string{"", "-1", "10X", "1.5M", "8589934592G", "9999999999G", "99999999999999999999"} {
+		_, err := util.ParseSize(s)
+		assert.NotNil(t, err)
+	}
+}